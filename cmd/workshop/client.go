@@ -0,0 +1,99 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// client sends requests to the application being checked.
+type client struct {
+	base string
+	// weather is the fake weather API, nil if it couldn't be started.
+	weather *weatherAPI
+}
+
+// response is the response to a request, with the time it took.
+type response struct {
+	status   int
+	header   http.Header
+	body     []byte
+	duration time.Duration
+}
+
+func (c *client) do(method, path, contentType string, body []byte) (*response, error) {
+	req, err := http.NewRequest(method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	start := time.Now()
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach the application, is it running? %v", err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response to %s %s: %v", method, path, err)
+	}
+	return &response{res.StatusCode, res.Header, b, time.Since(start)}, nil
+}
+
+func (c *client) get(path string) (*response, error) {
+	return c.do("GET", path, "", nil)
+}
+
+// post sends the given body, which is encoded as JSON unless it's a string.
+func (c *client) post(path string, body interface{}) (*response, error) {
+	b, ok := body.(string)
+	if !ok {
+		enc, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		b = string(enc)
+	}
+	return c.do("POST", path, "application/json", []byte(b))
+}
+
+// expectStatus returns an error if the response doesn't have the status.
+func (r *response) expectStatus(status int) error {
+	if r.status == status {
+		return nil
+	}
+	msg := strings.TrimSpace(string(r.body))
+	if len(msg) > 80 {
+		msg = msg[:80] + "..."
+	}
+	return fmt.Errorf("expected status %d %s, got %d %s: %q",
+		status, http.StatusText(status), r.status, http.StatusText(r.status), msg)
+}
+
+// decode decodes the JSON body of the response into v.
+func (r *response) decode(v interface{}) error {
+	if err := json.Unmarshal(r.body, v); err != nil {
+		return fmt.Errorf("could not decode JSON response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The workshop command checks the solutions to the steps of the events
+// workshop, by sending requests to the application running locally and
+// checking its responses.
+//
+// Run the step with dev_appserver.py and then, from any directory:
+//
+//	$ go run github.com/campoy/go-web-workshop/cmd/workshop check step1
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: workshop [flags] check <step>\n\n")
+	fmt.Fprintf(os.Stderr, "Checks the solution to a step of the events workshop, one of:\n\n")
+	for _, s := range steps {
+		fmt.Fprintf(os.Stderr, "\t%s\t%s\n", s.name, s.title)
+	}
+	fmt.Fprintf(os.Stderr, "\nThe application must be running, with dev_appserver.py for instance.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	addr := flag.String("addr", "http://localhost:8080", "address of the running application")
+	weatherAddr := flag.String("weather-addr", "localhost:8081", "address of the fake weather API checking the weather is cached")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 2 || flag.Arg(0) != "check" {
+		usage()
+		os.Exit(2)
+	}
+	s := findStep(flag.Arg(1))
+	if s == nil {
+		fmt.Fprintf(os.Stderr, "unknown step %q\n\n", flag.Arg(1))
+		usage()
+		os.Exit(2)
+	}
+
+	c := &client{base: strings.TrimSuffix(*addr, "/")}
+	if api, err := startWeatherAPI(*weatherAddr); err != nil {
+		fmt.Fprintf(os.Stderr, "could not start the fake weather API: %v\n", err)
+	} else {
+		c.weather = api
+	}
+	if _, err := c.get("/"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Checking %s: %s\n\n", s.name, s.title)
+	failed := 0
+	for _, req := range s.requirements {
+		if err := req.check(c); err != nil {
+			failed++
+			fmt.Printf("FAIL %s\n     %v\n     hint: %s\n", req.name, err, req.hint)
+			continue
+		}
+		fmt.Printf("ok   %s\n", req.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%d of %d requirements failed.\n", failed, len(s.requirements))
+		os.Exit(1)
+	}
+	fmt.Printf("\nAll requirements pass, well done!\n")
+}
@@ -0,0 +1,348 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// step is a step of the workshop, with the requirements its solution must
+// satisfy. The requirements of a step include the ones of previous steps,
+// unless the step changes them.
+type step struct {
+	name         string
+	title        string
+	requirements []requirement
+}
+
+// requirement is a behavior of the application, with a hint to the part of
+// the step implementing it.
+type requirement struct {
+	name  string
+	hint  string
+	check func(c *client) error
+}
+
+var steps = []step{
+	{"step0", "basic architecture", []requirement{
+		servesPage,
+		listsFixedEvents,
+		addReturnsCreated,
+	}},
+	{"step1", "JSON and local storage", storage},
+	{"step2", "durable storage", storage},
+	{"step3", "adding weather with openweathermap.org", append(storage[:len(storage):len(storage)],
+		addsWeather,
+	)},
+	{"step4", "storing temporary results in Memcache", append(storage[:len(storage):len(storage)],
+		addsWeather,
+		cachesWeather,
+	)},
+}
+
+// findStep returns the step with the given name, which can also be just
+// its number, or nil if there's none.
+func findStep(name string) *step {
+	if !strings.HasPrefix(name, "step") {
+		name = "step" + name
+	}
+	for i := range steps {
+		if steps[i].name == name {
+			return &steps[i]
+		}
+	}
+	return nil
+}
+
+// event is an event as encoded by the application.
+type event struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Date        string   `json:"date"`
+	Location    string   `json:"location"`
+	Weather     *weather `json:"weather"`
+}
+
+type weather struct {
+	Description string `json:"description"`
+	Icon        string `json:"icon"`
+}
+
+// storage are the requirements for the steps storing events, both in memory
+// and in the datastore.
+var storage = []requirement{
+	servesPage,
+	addReturnsCreated,
+	listsAddedEvent,
+	listIsJSON,
+	rejectsInvalidJSON,
+	rejectsMissing("title"),
+	rejectsMissing("location"),
+	rejectsInvalidDate,
+}
+
+var servesPage = requirement{
+	name: "GET / serves the events page",
+	hint: "check the handlers in app.yaml serve static/index.html for /.",
+	check: func(c *client) error {
+		res, err := c.get("/")
+		if err != nil {
+			return err
+		}
+		return res.expectStatus(http.StatusOK)
+	},
+}
+
+var listsFixedEvents = requirement{
+	name: "GET /api/events returns the list of events",
+	hint: "register listEvents for GET requests to /api/events, and write listOutput to the response.",
+	check: func(c *client) error {
+		res, err := c.get("/api/events")
+		if err != nil {
+			return err
+		}
+		if err := res.expectStatus(http.StatusOK); err != nil {
+			return err
+		}
+		var events []event
+		if err := res.decode(&events); err != nil {
+			return err
+		}
+		if len(events) != 3 {
+			return fmt.Errorf("expected 3 events, got %d", len(events))
+		}
+		return nil
+	},
+}
+
+var addReturnsCreated = requirement{
+	name: "POST /api/events returns 201 Created",
+	hint: "register addEvent for POST requests to /api/events, and call w.WriteHeader(http.StatusCreated).",
+	check: func(c *client) error {
+		res, err := c.post("/api/events", newEvent("created"))
+		if err != nil {
+			return err
+		}
+		return res.expectStatus(http.StatusCreated)
+	},
+}
+
+var listsAddedEvent = requirement{
+	name: "GET /api/events returns the events added with POST",
+	hint: "decode all the fields in decodeEvent, store the event in addEvent, and encode the stored events in listEvents.",
+	check: func(c *client) error {
+		e := newEvent("listed")
+		if err := add(c, e); err != nil {
+			return err
+		}
+		got, err := find(c, e)
+		if err != nil {
+			return err
+		}
+		if got.Location != e.Location || got.Description != e.Description || !strings.HasPrefix(got.Date, e.Date) {
+			return fmt.Errorf("expected %+v, got %+v", *e, *got)
+		}
+		return nil
+	},
+}
+
+var listIsJSON = requirement{
+	name: "GET /api/events sets Content-Type to application/json",
+	hint: `call w.Header().Set("Content-Type", "application/json") before writing the events.`,
+	check: func(c *client) error {
+		res, err := c.get("/api/events")
+		if err != nil {
+			return err
+		}
+		if ct := res.header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			return fmt.Errorf("expected Content-Type application/json, got %q", ct)
+		}
+		return nil
+	},
+}
+
+var rejectsInvalidJSON = requirement{
+	name: "POST /api/events rejects invalid JSON with 400 Bad Request",
+	hint: "return the error from the json.Decoder in decodeEvent.",
+	check: func(c *client) error {
+		res, err := c.post("/api/events", "{not json")
+		if err != nil {
+			return err
+		}
+		return res.expectStatus(http.StatusBadRequest)
+	},
+}
+
+func rejectsMissing(field string) requirement {
+	return requirement{
+		name: fmt.Sprintf("POST /api/events rejects events without %s with 400 Bad Request", field),
+		hint: fmt.Sprintf("return an error from decodeEvent if the %s is empty.", field),
+		check: func(c *client) error {
+			e := newEvent("missing " + field)
+			m := map[string]string{"title": e.Title, "date": e.Date, "location": e.Location, "description": e.Description}
+			delete(m, field)
+			res, err := c.post("/api/events", m)
+			if err != nil {
+				return err
+			}
+			return res.expectStatus(http.StatusBadRequest)
+		},
+	}
+}
+
+var rejectsInvalidDate = requirement{
+	name: "POST /api/events rejects invalid dates with 400 Bad Request",
+	hint: `parse the date in decodeEvent with time.Parse("2006-01-02", data.Date) and return the error.`,
+	check: func(c *client) error {
+		e := newEvent("invalid date")
+		e.Date = "tomorrow"
+		res, err := c.post("/api/events", e)
+		if err != nil {
+			return err
+		}
+		return res.expectStatus(http.StatusBadRequest)
+	},
+}
+
+var addsWeather = requirement{
+	name: "GET /api/events includes the weather of each event",
+	hint: "call weather for each event in listEvents, and check WEATHER_API_KEY in app.yaml is a valid key.",
+	check: func(c *client) error {
+		e := newEvent("weather")
+		if err := add(c, e); err != nil {
+			return err
+		}
+		got, err := find(c, e)
+		if err != nil {
+			return err
+		}
+		if got.Weather == nil || got.Weather.Description == "" {
+			return fmt.Errorf("the event has no weather, look for errors in the logs of dev_appserver.py")
+		}
+		if !strings.HasPrefix(got.Weather.Icon, "http") {
+			return fmt.Errorf("expected the icon to be a URL, got %q", got.Weather.Icon)
+		}
+		return nil
+	},
+}
+
+// cachesWeather counts the requests to the fake weather API, which the
+// application only uses if it's run with its URL in WEATHER_API_URL.
+var cachesWeather = requirement{
+	name: "GET /api/events serves the weather from memcache after the first call",
+	hint: "check memcache with memcache.JSON.Get at the beginning of weather, and store the weather with memcache.JSON.Set.",
+	check: func(c *client) error {
+		if c.weather == nil {
+			return fmt.Errorf("the fake weather API is not running, pick another address for it with -weather-addr")
+		}
+		// A location not used before, so its weather is not cached yet. The
+		// weather API ignores the case of locations, but memcache doesn't.
+		e := newEvent("cache")
+		e.Location = randomCase("Amsterdam")
+		if err := add(c, e); err != nil {
+			return err
+		}
+		if _, err := find(c, e); err != nil {
+			return err
+		}
+		n := c.weather.count(e.Location)
+		if n == 0 {
+			return fmt.Errorf("the weather of %s was not requested from the fake weather API, "+
+				"run dev_appserver.py with --env_var WEATHER_API_URL=%s", e.Location, c.weather.url)
+		}
+		if _, err := find(c, e); err != nil {
+			return err
+		}
+		if m := c.weather.count(e.Location); m > n {
+			return fmt.Errorf("the weather of %s was requested again from the weather API when listing the events a second time", e.Location)
+		}
+		return nil
+	},
+}
+
+// newEvent returns a valid event for tomorrow, with a unique title.
+func newEvent(purpose string) *event {
+	return &event{
+		Title:       fmt.Sprintf("workshop check %s %d", purpose, time.Now().UnixNano()),
+		Description: "Added by the workshop checker.",
+		Date:        time.Now().AddDate(0, 0, 1).Format("2006-01-02"),
+		Location:    "Paris",
+	}
+}
+
+// randomCase returns s with the case of its letters changed randomly.
+func randomCase(s string) string {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	r := []rune(strings.ToLower(s))
+	for i := range r {
+		if rnd.Intn(2) == 0 {
+			r[i] = unicode.ToUpper(r[i])
+		}
+	}
+	return string(r)
+}
+
+// add adds the event through the API.
+func add(c *client, e *event) error {
+	res, err := c.post("/api/events", e)
+	if err != nil {
+		return err
+	}
+	return res.expectStatus(http.StatusCreated)
+}
+
+// listLimit is the number of events listed from step2 on, the first ones by
+// date.
+const listLimit = 5
+
+// find returns the listed event with the title of e.
+func find(c *client, e *event) (*event, error) {
+	res, err := c.get("/api/events")
+	if err != nil {
+		return nil, err
+	}
+	if err := res.expectStatus(http.StatusOK); err != nil {
+		return nil, err
+	}
+	var events []event
+	if err := res.decode(&events); err != nil {
+		return nil, err
+	}
+	for i := range events {
+		if events[i].Title == e.Title {
+			return &events[i], nil
+		}
+	}
+	// Events added by previous runs of the checker on the same day can fill
+	// the list, since they're on the same date, and hide the new one.
+	if n := len(events); n >= listLimit && day(events[n-1].Date) <= e.Date {
+		return nil, fmt.Errorf("the event %q was not listed, but the %d listed events are on or before %s, "+
+			"probably added by previous runs of the checker: restart dev_appserver.py with --clear_datastore", e.Title, n, e.Date)
+	}
+	return nil, fmt.Errorf("the event %q was not in the list of %d events", e.Title, len(events))
+}
+
+// day returns the day of a date listed by the application, which can also
+// have a time.
+func day(date string) string {
+	if len(date) > len("2006-01-02") {
+		return date[:len("2006-01-02")]
+	}
+	return date
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// weatherAPI is a fake of the openweathermap.org API, counting the requests
+// made by the application for each location.
+type weatherAPI struct {
+	// url is the URL the application must use instead of the real API.
+	url string
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+// startWeatherAPI serves a fake weather API on the given address.
+func startWeatherAPI(addr string) (*weatherAPI, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	api := &weatherAPI{
+		url:      "http://" + l.Addr().String() + "/data/2.5/weather",
+		requests: make(map[string]int),
+	}
+	go http.Serve(l, api)
+	return api, nil
+}
+
+func (api *weatherAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	api.requests[r.FormValue("q")]++
+	api.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"weather": []weather{{Description: "clear sky", Icon: "01d"}},
+	})
+}
+
+// count returns the number of requests for the weather of location.
+func (api *weatherAPI) count(location string) int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.requests[location]
+}
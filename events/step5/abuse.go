@@ -0,0 +1,180 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+
+	// maxBodySize is the size of the largest request body accepted, except
+	// by the import routes which accept up to maxImportSize.
+	maxBodySize   = 64 << 10
+	maxImportSize = 8 << 20
+
+	// honeypotField is a field of the form in the events page hidden to
+	// people, so only bots fill it.
+	honeypotField = "website"
+)
+
+// unlimitedPaths are the prefixes of the paths that are not rate limited,
+// since only administrators and App Engine can use them.
+var unlimitedPaths = []string{"/api/cron/", "/api/tasks/", "/api/admin/"}
+
+// bucket is the token bucket of a client, cached while it's being refilled.
+// Each request changing data takes a token, and the bucket is refilled at
+// cfg.RateLimit tokens per minute up to cfg.RateLimit tokens.
+type bucket struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+// limitRate refuses the requests changing data from clients that have no
+// tokens left in their bucket, with status 429. Clients are identified by
+// their API key or, without one, their IP address.
+func limitRate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.RateLimit == 0 || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" || unlimited(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx := platform.NewContext(r)
+
+		client, ok := rateLimitKey(r)
+		if !ok {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		wait, err := takeToken(ctx, client, cfg.RateLimit, time.Now())
+		if err != nil {
+			// It's better to let some requests through than to refuse all
+			// of them while the cache is not available.
+			platform.Errorf(ctx, "rate limiting %s: %v", client, err)
+		} else if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests, retry later", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func unlimited(path string) bool {
+	for _, p := range unlimitedPaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitKey returns the cache key of the bucket of the client making the
+// request, or false if it sent an unknown API key.
+func rateLimitKey(r *http.Request) (string, bool) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		for _, k := range strings.Split(cfg.APIKeys, ",") {
+			if k = strings.TrimSpace(k); k != "" && subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				// The key itself is a secret, so it's not used in the cache.
+				return fmt.Sprintf("ratelimit:key:%x", sha256.Sum256([]byte(key))), true
+			}
+		}
+		return "", false
+	}
+	return "ratelimit:ip:" + clientIP(r), true
+}
+
+// clientIP returns the IP address of the client making the request. On App
+// Engine it's the address of the client, but outside App Engine it's the one
+// of the last proxy if there are any, such as a load balancer, which all
+// the clients behind it share. X-Forwarded-For is not used since clients can
+// set it to anything, unless the proxy replaces it.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// takeToken takes a token from the bucket with the given key, shared by all
+// the instances through the cache. If the bucket is empty it returns how long
+// until there's a token again.
+func takeToken(ctx context.Context, key string, rate int, now time.Time) (time.Duration, error) {
+	perToken := time.Minute / time.Duration(rate)
+	var wait time.Duration
+	var b bucket
+	err := platform.CacheUpdate(ctx, key, &b, time.Minute, func(found bool) error {
+		if !found {
+			b = bucket{Tokens: float64(rate), Updated: now}
+		}
+		if elapsed := now.Sub(b.Updated); elapsed > 0 {
+			b.Tokens = math.Min(float64(rate), b.Tokens+elapsed.Minutes()*float64(rate))
+			b.Updated = now
+		}
+		if b.Tokens < 1 {
+			wait = time.Duration((1 - b.Tokens) * float64(perToken))
+		} else {
+			wait = 0
+			b.Tokens--
+		}
+		return nil
+	})
+	return wait, err
+}
+
+// limitBody refuses request bodies larger than maxBodySize, or maxImportSize
+// for imports, with status 413. Bodies without a Content-Length fail to be
+// read past the limit.
+func limitBody(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := int64(maxBodySize)
+		if strings.HasSuffix(r.URL.Path, "/events:import") {
+			limit = maxImportSize
+		}
+		if r.ContentLength > limit {
+			http.Error(w, fmt.Sprintf("request body larger than %d bytes", limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// bodyTooLarge returns whether err comes from reading a body larger than
+// the limit set by limitBody.
+func bodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// isBot returns whether the form in the request has the honeypot field,
+// which people can't see, filled.
+func isBot(r *http.Request) bool {
+	return r.FormValue(honeypotField) != ""
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+// yearFormat is the format of the year parameter of the archive.
+const yearFormat = "2006"
+
+// listArchive lists the past events in the calendar, the most recent first,
+// optionally only the ones in the year or month in the parameters.
+func listArchive(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	from, to, err := archivePeriod(r.FormValue("year"), r.FormValue("month"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := platform.NewQuery(eventKind).
+		Ancestor(calendar).
+		Filter("Date <=", time.Now()).
+		Order("-Date")
+	if !from.IsZero() {
+		q = q.Filter("Date >=", from).Filter("Date <", to)
+	}
+	serveEventPage(w, r, q)
+}
+
+// archivePeriod returns the first day of the year or month given, formatted
+// as yearFormat or monthFormat, and the first day after it. Without any,
+// both are zero.
+func archivePeriod(year, month string) (from, to time.Time, err error) {
+	switch {
+	case year != "" && month != "":
+		return from, to, errors.New("year and month can't be used together")
+	case year != "":
+		if from, err = time.Parse(yearFormat, year); err != nil {
+			return from, to, fmt.Errorf("invalid year %q, use the YYYY format as in 2025", year)
+		}
+		return from, from.AddDate(1, 0, 0), nil
+	case month != "":
+		if from, err = time.Parse(monthFormat, month); err != nil {
+			return from, to, fmt.Errorf("invalid month %q, use the YYYY-MM format as in 2025-11", month)
+		}
+		return from, from.AddDate(0, 1, 0), nil
+	}
+	return from, to, nil
+}
+
+// pageLimit returns the number of events to list given in the limit
+// parameter, defaultPageSize if there's none.
+func pageLimit(r *http.Request) (int, error) {
+	s := r.FormValue("limit")
+	if s == "" {
+		return defaultPageSize, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 || n > maxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	return n, nil
+}
+
+// serveEventPage serves the events found by q, a page at a time. The limit
+// parameter is the size of the pages, and the cursor parameter the position
+// of the page, as given in the Link header of the previous one.
+func serveEventPage(w http.ResponseWriter, r *http.Request, q *platform.Query) {
+	ctx := platform.NewContext(r)
+
+	limit, err := pageLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursor := r.FormValue("cursor")
+	if cursor != "" {
+		q = q.Start(cursor)
+	}
+
+	// We fetch one more event than requested to know if there's a next page.
+	events := []Event{}
+	var next string
+	more := false
+	start := time.Now()
+	t := q.Limit(limit + 1).Run(ctx)
+	for {
+		var e Event
+		key, err := t.Next(&e)
+		if err == platform.Done {
+			break
+		}
+		if err != nil && cursor != "" && len(events) == 0 {
+			http.Error(w, fmt.Sprintf("invalid cursor: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(events) == limit {
+			more = true
+			break
+		}
+		e.setKey(key)
+		events = append(events, e)
+		if len(events) == limit {
+			// The next page starts after the last event of this one.
+			if next, err = t.Cursor(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	storeDuration.WithLabelValues("event page").Observe(time.Since(start).Seconds())
+
+	if more {
+		u := *r.URL
+		params := u.Query()
+		params.Set("cursor", next)
+		u.RawQuery = params.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", u.RequestURI()))
+	}
+	serveEncoded(ctx, w, r, "", time.Time{}, eventList(events))
+}
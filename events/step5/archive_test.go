@@ -0,0 +1,99 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+// nextLink matches the Link header of pages with a next one.
+var nextLink = regexp.MustCompile(`^<([^>]+)>; rel="next"$`)
+
+func TestArchivePages(t *testing.T) {
+	setup(t)
+
+	calendar := calendarKey(defaultCalendar)
+	var keys []*platform.Key
+	var events []*Event
+	for i, date := range []string{"2024-12-31", "2025-01-15", "2025-03-01", "2025-03-20", "2025-11-05", "2099-01-01"} {
+		d, err := time.Parse(dateFormat, date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, platform.NewIncompleteKey(eventKind, calendar))
+		events = append(events, &Event{Title: fmt.Sprintf("Event %d", i), Date: d, Location: "Paris"})
+	}
+	if _, err := platform.PutMulti(context.Background(), keys, events); err != nil {
+		t.Fatal(err)
+	}
+
+	// pages follows the Link headers from target, returning the titles in
+	// each page.
+	pages := func(target string) []string {
+		var got []string
+		for target != "" {
+			w := serve("GET", target, "")
+			expect(t, w, http.StatusOK)
+			got = append(got, titles(t, w))
+			target = ""
+			if link := w.Header().Get("Link"); link != "" {
+				m := nextLink.FindStringSubmatch(link)
+				if m == nil {
+					t.Fatalf("invalid Link header %q", link)
+				}
+				target = m[1]
+			}
+			if len(got) > 10 {
+				t.Fatalf("too many pages: %q", got)
+			}
+		}
+		return got
+	}
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/api/events/archive", []string{"Event 4,Event 3,Event 2,Event 1,Event 0"}},
+		{"/api/events/archive?limit=2", []string{"Event 4,Event 3", "Event 2,Event 1", "Event 0"}},
+		// The last page is full, but there's no next one.
+		{"/api/events/archive?limit=5", []string{"Event 4,Event 3,Event 2,Event 1,Event 0"}},
+		{"/api/events/archive?year=2025&limit=3", []string{"Event 4,Event 3,Event 2", "Event 1"}},
+		{"/api/events/archive?month=2025-03&limit=1", []string{"Event 3", "Event 2"}},
+	}
+	for _, tt := range tests {
+		if got := pages(tt.target); strings.Join(got, " | ") != strings.Join(tt.want, " | ") {
+			t.Errorf("%s: got pages %q, want %q", tt.target, got, tt.want)
+		}
+	}
+
+	// The Link header keeps the other parameters.
+	w := serve("GET", "/api/events/archive?year=2025&limit=3", "")
+	expect(t, w, http.StatusOK)
+	if got, want := w.Header().Get("Link"), `</api/events/archive?cursor=3&limit=3&year=2025>; rel="next"`; got != want {
+		t.Errorf("got Link %q, want %q", got, want)
+	}
+
+	expect(t, serve("GET", "/api/events/archive?cursor=bogus", ""), http.StatusBadRequest)
+	expect(t, serve("GET", "/api/events/archive?limit=101", ""), http.StatusBadRequest)
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// assetPaths maps the paths of the static files to their fingerprinted
+// paths, set by StaticHandler. It's empty on App Engine, where the static
+// files are served by app.yaml.
+var assetPaths = map[string]string{}
+
+// assetPath returns the path to use in pages for the static file at p.
+func assetPath(p string) string {
+	if fp, ok := assetPaths[p]; ok {
+		return fp
+	}
+	return p
+}
+
+// asset is a static file, with its compressed variants.
+type asset struct {
+	name        string
+	contentType string
+	modTime     time.Time
+	hash        string
+	// immutable assets have the hash in their path, so their content never
+	// changes.
+	immutable bool
+	// variants contains the content by coding: "", "gzip" and "br".
+	variants map[string][]byte
+}
+
+// StaticHandler returns a handler serving the files in dir, for when they're
+// not served by App Engine. Files are loaded and compressed once.
+//
+// Each file, except HTML ones, is also served under a name containing a hash
+// of its content, such as /script.1b2c3d4e.js, with headers letting browsers
+// cache it forever. References to those files in HTML files and the events
+// page are replaced by the fingerprinted names, so a new version of a file
+// is fetched as soon as it changes.
+func StaticHandler(dir string) (http.Handler, error) {
+	files := make(map[string][]byte)
+	modTimes := make(map[string]time.Time)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := "/" + filepath.ToSlash(rel)
+		files[name], modTimes[name] = b, info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read static files: %v", err)
+	}
+
+	paths := make(map[string]string)
+	for name, b := range files {
+		if !isHTML(name) {
+			ext := path.Ext(name)
+			sum := sha256.Sum256(b)
+			paths[name] = fmt.Sprintf("%s.%x%s", strings.TrimSuffix(name, ext), sum[:4], ext)
+		}
+	}
+
+	s := staticHandler{}
+	for name, b := range files {
+		if isHTML(name) {
+			b = rewriteReferences(b, paths)
+		}
+		a, err := newAsset(name, b, modTimes[name])
+		if err != nil {
+			return nil, err
+		}
+		s[name] = a
+		if fp, ok := paths[name]; ok {
+			immutable := *a
+			immutable.immutable = true
+			s[fp] = &immutable
+		}
+	}
+	assetPaths = paths
+	return s, nil
+}
+
+func isHTML(name string) bool { return path.Ext(name) == ".html" }
+
+// rewriteReferences replaces the quoted references to the files in paths by
+// their fingerprinted paths.
+func rewriteReferences(b []byte, paths map[string]string) []byte {
+	for name, fp := range paths {
+		for _, q := range []string{`"`, `'`} {
+			b = bytes.Replace(b, []byte(q+name+q), []byte(q+fp+q), -1)
+		}
+	}
+	return b
+}
+
+func newAsset(name string, b []byte, modTime time.Time) (*asset, error) {
+	a := &asset{
+		name:        name,
+		contentType: mime.TypeByExtension(path.Ext(name)),
+		modTime:     modTime,
+		hash:        fmt.Sprintf("%x", sha256.Sum256(b)),
+		variants:    map[string][]byte{"": b},
+	}
+	if a.contentType == "" {
+		a.contentType = http.DetectContentType(b)
+	}
+	if len(b) < minCompressSize {
+		return a, nil
+	}
+
+	var gz, br bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	bw := brotli.NewWriterLevel(&br, brotli.BestCompression)
+	for _, w := range []interface {
+		Write([]byte) (int, error)
+		Close() error
+	}{gw, bw} {
+		if _, err := w.Write(b); err != nil {
+			return nil, fmt.Errorf("could not compress %s: %v", name, err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("could not compress %s: %v", name, err)
+		}
+	}
+	a.variants["gzip"], a.variants["br"] = gz.Bytes(), br.Bytes()
+	return a, nil
+}
+
+// staticHandler serves assets by path, in their compressed variants when
+// the client accepts them.
+type staticHandler map[string]*asset
+
+func (s staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a, ok := s[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	coding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+	if _, ok := a.variants[coding]; !ok {
+		coding = ""
+	}
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	h.Set("Content-Type", a.contentType)
+	if coding != "" {
+		h.Set("Content-Encoding", coding)
+		h.Set("ETag", fmt.Sprintf(`"%s-%s"`, a.hash, coding))
+	} else {
+		h.Set("ETag", fmt.Sprintf(`"%s"`, a.hash))
+	}
+	if a.immutable {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, a.name, a.modTime, bytes.NewReader(a.variants[coding]))
+}
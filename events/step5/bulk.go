@@ -0,0 +1,359 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+// Formats supported by the import and export endpoints.
+const (
+	csvFormat   = "csv"
+	jsonlFormat = "jsonl"
+)
+
+// importTypes are the media types accepted by the import endpoint. The types
+// of HTML forms aren't, so that other sites can't import events.
+var importTypes = []string{"text/csv", "application/x-ndjson", "application/json"}
+
+// importBatchSize is the number of events imported in each transaction,
+// with a single PutMulti for the events and another one for their first
+// revisions. Datastore accepts up to 500 entities in a transaction.
+const importBatchSize = 250
+
+// csvHeader contains the columns used when importing and exporting CSV.
+var csvHeader = []string{"title", "date", "location", "description", "latitude", "longitude", "days"}
+
+// optionalColumns are the columns in csvHeader that imports may omit.
+var optionalColumns = map[string]bool{"latitude": true, "longitude": true, "days": true}
+
+// importRow is a single row read from an import request, with either
+// the decoded event or the reason why it is not valid.
+type importRow struct {
+	line  int
+	event *Event
+	err   error
+}
+
+// rowError describes why a row could not be imported.
+type rowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// importReport is the response of the import endpoint.
+type importReport struct {
+	DryRun   bool       `json:"dry_run"`
+	Rows     int        `json:"rows"`
+	Valid    int        `json:"valid"`
+	Imported int        `json:"imported"`
+	Errors   []rowError `json:"errors"`
+}
+
+func importEvents(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	if readOnly(w) || !requireUser(w, r) || !checkCaptcha(w, r) || !requireType(w, r, importTypes...) {
+		return
+	}
+
+	format, err := bulkFormat(r.FormValue("format"), r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rows []importRow
+	switch format {
+	case csvFormat:
+		rows, err = readCSV(r.Body)
+	case jsonlFormat:
+		rows, err = readJSONL(r.Body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := importReport{
+		DryRun: r.FormValue("dry_run") == "true",
+		Rows:   len(rows),
+		Errors: []rowError{},
+	}
+
+	// Events are imported as if they were created one by one, so the ones
+	// already in the calendar, or earlier in the import, are skipped unless
+	// allow_duplicate is true, and webhooks are notified.
+	allowDuplicate := r.FormValue("allow_duplicate") == "true"
+	who := author(r)
+	seen := make(map[string]bool)
+	var batch []importRow
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var created []*Event
+		var duplicates []rowError
+		insert := func(ctx context.Context) error {
+			created, duplicates = nil, nil
+			for _, row := range batch {
+				// Each attempt of the transaction works on new copies.
+				e := *row.event
+				if !allowDuplicate {
+					err := findDuplicate(ctx, calendar, &e)
+					if err == errDuplicateEvent {
+						duplicates = append(duplicates, rowError{row.line, fmt.Sprintf("%v: %s", err, eventPath(&e))})
+						continue
+					} else if err != nil {
+						return err
+					}
+				}
+				created = append(created, &e)
+			}
+			if report.DryRun {
+				created = nil
+				return nil
+			}
+			return insertEvents(ctx, calendar, created, who)
+		}
+		var err error
+		if report.DryRun {
+			err = insert(ctx)
+		} else {
+			err = platform.RunInTransaction(ctx, insert, false)
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range created {
+			notifyWebhooks(ctx, eventCreated, e)
+		}
+		report.Imported += len(created)
+		report.Valid -= len(duplicates)
+		report.Errors = append(report.Errors, duplicates...)
+		batch = batch[:0]
+		return nil
+	}
+
+	for _, row := range rows {
+		if row.err != nil {
+			report.Errors = append(report.Errors, rowError{row.line, row.err.Error()})
+			continue
+		}
+		id := fmt.Sprintf("%q %v %q", row.event.Title, row.event.Date, row.event.Location)
+		if !allowDuplicate && seen[id] {
+			report.Errors = append(report.Errors, rowError{row.line, "the same event is in an earlier row"})
+			continue
+		}
+		seen[id] = true
+		report.Valid++
+		batch = append(batch, row)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				http.Error(w, fmt.Sprintf("imported %d events before failing: %v", report.Imported, err), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		http.Error(w, fmt.Sprintf("imported %d events before failing: %v", report.Imported, err), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Row < report.Errors[j].Row })
+
+	writeJSON(ctx, w, http.StatusOK, report)
+}
+
+func exportEvents(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	format, err := bulkFormat(r.FormValue("format"), r.Header.Get("Accept"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var write func(eventInput) error
+	switch format {
+	case csvFormat:
+		cw := csv.NewWriter(w)
+		defer cw.Flush()
+		write = func(in eventInput) error {
+			return cw.Write([]string{in.Title, in.Date, in.Location, in.Description,
+				formatCoordinate(in.Latitude), formatCoordinate(in.Longitude), formatDays(int(in.Days))})
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="events.csv"`)
+		if err := cw.Write(csvHeader); err != nil {
+			platform.Errorf(ctx, "writing CSV header: %v", err)
+			return
+		}
+	case jsonlFormat:
+		enc := json.NewEncoder(w)
+		write = func(in eventInput) error { return enc.Encode(in) }
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="events.jsonl"`)
+	}
+
+	// Once we start writing the response we can't change the status code,
+	// so errors from here on are only logged.
+	t := platform.NewQuery(eventKind).Ancestor(calendar).Order("Date").Run(ctx)
+	for {
+		var e Event
+		_, err := t.Next(&e)
+		if err == platform.Done {
+			return
+		}
+		if err != nil {
+			platform.Errorf(ctx, "fetching events to export: %v", err)
+			return
+		}
+		if err := write(e.input()); err != nil {
+			platform.Errorf(ctx, "writing exported event: %v", err)
+			return
+		}
+	}
+}
+
+// bulkFormat returns the format requested either explicitly with the format
+// parameter or through the given media type.
+func bulkFormat(param, mediaType string) (string, error) {
+	switch {
+	case param == csvFormat, param == jsonlFormat:
+		return param, nil
+	case param != "":
+		return "", fmt.Errorf("unknown format %q, use %q or %q", param, csvFormat, jsonlFormat)
+	case strings.Contains(mediaType, "text/csv"):
+		return csvFormat, nil
+	default:
+		return jsonlFormat, nil
+	}
+}
+
+// readCSV reads the rows of a CSV document whose first line is a header
+// with the names of the columns in csvHeader.
+func readCSV(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read CSV header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvHeader {
+		if _, ok := columns[name]; !ok && !optionalColumns[name] {
+			return nil, fmt.Errorf("missing column %q in CSV header", name)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if perr, ok := err.(*csv.ParseError); ok && perr.Err == csv.ErrFieldCount {
+			rows = append(rows, importRow{line: perr.Line, err: perr.Err})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read CSV: %v", err)
+		}
+		line, _ := cr.FieldPos(0)
+		in := eventInput{
+			Title:       record[columns["title"]],
+			Date:        record[columns["date"]],
+			Location:    record[columns["location"]],
+			Description: record[columns["description"]],
+		}
+		var e *Event
+		if in.Latitude, in.Longitude, err = csvCoordinates(record, columns); err == nil {
+			if in.Days, err = csvDays(record, columns); err == nil {
+				e, err = in.event()
+			}
+		}
+		rows = append(rows, importRow{line, e, err})
+	}
+}
+
+// csvCoordinates returns the coordinates in the optional columns of the
+// record, nil if they're missing or empty.
+func csvCoordinates(record []string, columns map[string]int) (lat, lon *float64, err error) {
+	parse := func(name string) (*float64, error) {
+		i, ok := columns[name]
+		if !ok {
+			return nil, nil
+		}
+		return parseCoordinate(record[i])
+	}
+	if lat, err = parse("latitude"); err != nil {
+		return nil, nil, err
+	}
+	if lon, err = parse("longitude"); err != nil {
+		return nil, nil, err
+	}
+	return lat, lon, nil
+}
+
+// csvDays returns the number of days in the optional column of the record,
+// zero if it's missing or empty.
+func csvDays(record []string, columns map[string]int) (int32, error) {
+	i, ok := columns["days"]
+	if !ok || strings.TrimSpace(record[i]) == "" {
+		return 0, nil
+	}
+	days, err := strconv.ParseInt(strings.TrimSpace(record[i]), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number of days %q", record[i])
+	}
+	return int32(days), nil
+}
+
+// readJSONL reads a document with one JSON encoded event per line, as
+// accepted by addEvent. Empty lines are ignored.
+func readJSONL(r io.Reader) ([]importRow, error) {
+	var rows []importRow
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		b := bytes.TrimSpace(s.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		e, err := decodeEvent(bytes.NewReader(b))
+		rows = append(rows, importRow{line, e, err})
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("could not read JSON lines: %v", err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+// importCSV sends the CSV document to the import endpoint.
+func importCSV(t *testing.T, csv, params string) (*importReport, int) {
+	t.Helper()
+	w := serve("POST", "/api/events:import?format=csv"+params, csv, "Content-Type", "text/csv")
+	if w.Code != http.StatusOK {
+		return nil, w.Code
+	}
+	var report importReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return &report, w.Code
+}
+
+func TestImportMalformedCSV(t *testing.T) {
+	setup(t)
+	for _, csv := range []string{
+		"title,date,location,description\n\"a\"x,2099-01-01,Paris,\n",
+		"\"a\"x,date,location,description\n",
+		"title,date,location\n",
+	} {
+		if _, code := importCSV(t, csv, ""); code != http.StatusBadRequest {
+			t.Errorf("importing %q: got status %d, want %d", csv, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	f := setup(t)
+	expect(t, serve("POST", "/api/webhooks", `{"url": "https://example.com/hook"}`), http.StatusCreated)
+	expect(t, serve("POST", "/api/events", `{"title": "Meetup", "date": "2099-01-01", "location": "Paris"}`), http.StatusCreated)
+	f.Queue.Tasks()
+
+	csv := "title,date,location,description\n" +
+		"Meetup,2099-01-01,Paris,\n" + // already in the calendar
+		"Workshop,2099-01-02,Paris,\n" +
+		"Workshop,2099-01-02,Paris,\n" + // same as the previous row
+		"Talk,2099-01-03\n" + // wrong number of fields
+		"Talk,tomorrow,Paris,\n"
+
+	report, code := importCSV(t, csv, "&dry_run=true")
+	if code != http.StatusOK {
+		t.Fatalf("dry run: got status %d", code)
+	}
+	if report.Rows != 5 || report.Valid != 1 || report.Imported != 0 || len(report.Errors) != 4 {
+		t.Errorf("dry run: got report %+v", report)
+	}
+
+	report, code = importCSV(t, csv, "")
+	if code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if report.Valid != 1 || report.Imported != 1 {
+		t.Errorf("got report %+v", report)
+	}
+	var rows []string
+	for _, e := range report.Errors {
+		rows = append(rows, strconv.Itoa(e.Row))
+	}
+	if got, want := strings.Join(rows, ","), "2,4,5,6"; got != want {
+		t.Errorf("got errors in rows %s, want %s: %+v", got, want, report.Errors)
+	}
+	if tasks := f.Queue.Tasks(); len(tasks) != 1 {
+		t.Errorf("got %d webhook deliveries, want 1", len(tasks))
+	}
+
+	w := serve("GET", "/api/events/calendar?month=2099-01", "")
+	expect(t, w, http.StatusOK)
+	if n := strings.Count(w.Body.String(), `"title":"Workshop"`); n != 1 {
+		t.Errorf("got %d workshops, want 1", n)
+	}
+}
+
+// countingStore counts the calls to Put and PutMulti.
+type countingStore struct {
+	platform.Store
+	puts, putMultis int
+}
+
+func (s *countingStore) Put(ctx context.Context, key *platform.Key, src interface{}) (*platform.Key, error) {
+	s.puts++
+	return s.Store.Put(ctx, key, src)
+}
+
+func (s *countingStore) PutMulti(ctx context.Context, keys []*platform.Key, src interface{}) ([]*platform.Key, error) {
+	s.putMultis++
+	return s.Store.PutMulti(ctx, keys, src)
+}
+
+func TestImportBatches(t *testing.T) {
+	f := setup(t)
+	store := &countingStore{Store: f.Store}
+	platform.Current.Store = store
+
+	csv := "title,date,location,description\n" +
+		"Meetup,2099-01-01,Paris,\n" +
+		"Workshop,2099-01-02,Paris,\n" +
+		"Talk,2099-01-03,Paris,\n"
+	report, code := importCSV(t, csv, "")
+	if code != http.StatusOK || report.Imported != 3 {
+		t.Fatalf("got status %d and report %+v", code, report)
+	}
+	// The events and their revisions are stored with one PutMulti each.
+	if store.puts != 0 || store.putMultis != 2 {
+		t.Errorf("got %d calls to Put and %d to PutMulti, want 0 and 2", store.puts, store.putMultis)
+	}
+
+	var events []Event
+	keys, err := platform.NewQuery(eventKind).GetAll(context.Background(), &events)
+	if err != nil || len(keys) != 3 {
+		t.Fatalf("got %d events and error %v, want 3", len(keys), err)
+	}
+	for _, key := range keys {
+		w := serve("GET", "/api/events/"+strconv.FormatInt(key.IntID(), 10)+"/history", "")
+		expect(t, w, http.StatusOK)
+		if !strings.Contains(w.Body.String(), revisionCreated) {
+			t.Errorf("event %d has no creation revision: %s", key.IntID(), w.Body)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	calendarKind = "Calendar"
+
+	// defaultCalendar is the calendar of the events under /api/events.
+	// It exists even if it was never stored, with the default settings.
+	defaultCalendar = "default"
+
+	// calendarPath is the path template of a calendar in the router.
+	calendarPath = "/api/calendars/{calendar:[a-z0-9-]+}"
+
+	// defaultTimeZone is the time zone of calendars that don't set one.
+	defaultTimeZone = "UTC"
+)
+
+// calendarName matches the valid names of calendars.
+var calendarName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Errors returned when a calendar can't be created or changed.
+var (
+	errCalendarExists   = errors.New("calendar already exists")
+	errCalendarReadOnly = errors.New("this calendar is read only")
+	errNotCalendarAdmin = errors.New("only the admins of this calendar can change it")
+	errNotAppAdmin      = errors.New("only the administrators of the application can change the default calendar")
+	errSignInRequired   = errors.New("sign in to create or change calendars")
+)
+
+// Calendar groups the events of a user group. Events are stored as children
+// of their calendar, identified by its name.
+type Calendar struct {
+	Name        string `json:"name" datastore:"-"`
+	Title       string `json:"title"`
+	Description string `json:"description" datastore:",noindex"`
+	// Admins are the emails of the users that can change the calendar and
+	// its events. Everybody can change the events of calendars without
+	// admins, and any signed in user their settings, except for the default
+	// calendar, whose settings only the administrators of the application
+	// can change.
+	Admins []string `json:"admins"`
+	// ReadOnly calendars don't accept changes to their events.
+	ReadOnly bool `json:"read_only"`
+	// TimeZone is the name of the time zone of the calendar in the IANA
+	// database, such as Europe/Paris.
+	TimeZone string    `json:"time_zone"`
+	Created  time.Time `json:"created"`
+}
+
+// calendarKey returns the key of the calendar with the given name.
+func calendarKey(name string) *platform.Key {
+	return platform.NewKey(calendarKind, name, 0, nil)
+}
+
+// loadCalendar fetches the calendar with the given key, returning
+// platform.ErrNoSuchEntity if there's no such calendar.
+func loadCalendar(ctx context.Context, key *platform.Key) (*Calendar, error) {
+	var c Calendar
+	err := platform.Get(ctx, key, &c)
+	if err == platform.ErrNoSuchEntity && key.StringID() == defaultCalendar {
+		c, err = Calendar{Title: "Events", Admins: []string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Name = key.StringID()
+	c.setDefaults()
+	return &c, nil
+}
+
+// setDefaults sets the settings of calendars stored before they existed.
+func (c *Calendar) setDefaults() {
+	if c.TimeZone == "" {
+		c.TimeZone = defaultTimeZone
+	}
+}
+
+// location returns the time zone of the calendar.
+func (c *Calendar) location() *time.Location {
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		// Time zones are validated when calendars are changed, so this only
+		// happens if the time zone database changed.
+		return time.UTC
+	}
+	return loc
+}
+
+// checkChange returns an error unless the user making the request can
+// change the events in c. The administrators of the application can change
+// the events of any calendar that is not read only.
+func (c *Calendar) checkChange(ctx context.Context) error {
+	if c.ReadOnly {
+		return errCalendarReadOnly
+	}
+	if platform.IsAdmin(ctx) {
+		return nil
+	}
+	return c.checkAdmin(platform.CurrentUser(ctx))
+}
+
+// checkAdmin returns an error unless user can change the settings of c.
+func (c *Calendar) checkAdmin(user string) error {
+	if len(c.Admins) == 0 {
+		return nil
+	}
+	for _, admin := range c.Admins {
+		if strings.EqualFold(admin, user) {
+			return nil
+		}
+	}
+	return errNotCalendarAdmin
+}
+
+// checkSettings returns an error unless the user making the request can
+// change the settings of c. The administrators of the application can change
+// any calendar.
+func (c *Calendar) checkSettings(ctx context.Context) error {
+	if platform.IsAdmin(ctx) {
+		return nil
+	}
+	user := platform.CurrentUser(ctx)
+	switch {
+	case user == "":
+		return errSignInRequired
+	case c.Name == defaultCalendar && len(c.Admins) == 0:
+		return errNotAppAdmin
+	}
+	return c.checkAdmin(user)
+}
+
+// withCalendar calls h with the key of the calendar in the path, or of the
+// default calendar if there's none in the path. Requests changing the
+// calendar are refused unless the user is allowed to.
+type withCalendar struct {
+	h func(w http.ResponseWriter, r *http.Request, calendar *platform.Key)
+
+	// settings handlers change the calendar itself, rather than its events,
+	// so they're allowed on read only calendars.
+	settings bool
+}
+
+func (h withCalendar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	name := mux.Vars(r)["calendar"]
+	if name == "" {
+		name = defaultCalendar
+	}
+	key := calendarKey(name)
+	c, err := loadCalendar(ctx, key)
+	if err == platform.ErrNoSuchEntity {
+		http.Error(w, fmt.Sprintf("calendar %s not found", name), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("fetching calendar %s: %v", name, err), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		if h.settings {
+			err = c.checkSettings(ctx)
+		} else {
+			err = c.checkChange(ctx)
+		}
+		if err == errSignInRequired {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	h.h(w, r, key)
+}
+
+func listCalendars(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	calendars := []Calendar{}
+	keys, err := platform.NewQuery(calendarKind).GetAll(ctx, &calendars)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for i, key := range keys {
+		calendars[i].Name = key.StringID()
+		calendars[i].setDefaults()
+		found = found || key.StringID() == defaultCalendar
+	}
+	if !found {
+		def, err := loadCalendar(ctx, calendarKey(defaultCalendar))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		calendars = append([]Calendar{*def}, calendars...)
+	}
+	writeJSON(ctx, w, http.StatusOK, calendars)
+}
+
+func addCalendar(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	if readOnly(w) || !requireJSON(w, r) {
+		return
+	}
+	user := platform.CurrentUser(ctx)
+	if user == "" && !platform.IsAdmin(ctx) {
+		http.Error(w, errSignInRequired.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	c, err := decodeCalendar(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !calendarName.MatchString(c.Name) {
+		http.Error(w, "name must contain only lowercase letters, digits and dashes", http.StatusBadRequest)
+		return
+	}
+	// Whoever creates a calendar is its admin, unless they choose others.
+	if user != "" && len(c.Admins) == 0 {
+		c.Admins = []string{user}
+	}
+	c.Created = time.Now()
+
+	key := calendarKey(c.Name)
+	err = platform.RunInTransaction(ctx, func(ctx context.Context) error {
+		if _, err := loadCalendar(ctx, key); err != platform.ErrNoSuchEntity {
+			if err == nil {
+				return errCalendarExists
+			}
+			return err
+		}
+		_, err := platform.Put(ctx, key, c)
+		return err
+	}, false)
+	if err == errCalendarExists {
+		http.Error(w, fmt.Sprintf("calendar %s already exists", c.Name), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/api/calendars/"+c.Name)
+	writeJSON(ctx, w, http.StatusCreated, c)
+}
+
+func getCalendar(w http.ResponseWriter, r *http.Request, key *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	c, err := loadCalendar(ctx, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(ctx, w, http.StatusOK, c)
+}
+
+// updateCalendar changes the settings of a calendar. The name and creation
+// time of a calendar can't change.
+func updateCalendar(w http.ResponseWriter, r *http.Request, key *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	if readOnly(w) || !requireJSON(w, r) {
+		return
+	}
+
+	c, err := decodeCalendar(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = platform.RunInTransaction(ctx, func(ctx context.Context) error {
+		old, err := loadCalendar(ctx, key)
+		if err != nil {
+			return err
+		}
+		c.Name, c.Created = old.Name, old.Created
+		_, err = platform.Put(ctx, key, c)
+		return err
+	}, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(ctx, w, http.StatusOK, c)
+}
+
+// decodeCalendar decodes and validates the calendar in the request body.
+func decodeCalendar(r *http.Request) (*Calendar, error) {
+	var c Calendar
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		return nil, fmt.Errorf("could not decode JSON: %v", err)
+	}
+	if c.Title == "" {
+		return nil, errors.New("title is required")
+	}
+	if c.Admins == nil {
+		c.Admins = []string{}
+	}
+	c.setDefaults()
+	if _, err := time.LoadLocation(c.TimeZone); err != nil || c.TimeZone == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", c.TimeZone)
+	}
+	return &c, nil
+}
+
+// addCalendarDocs documents the routes on the events of a calendar, which
+// are the same as the ones on the events of the default calendar.
+func addCalendarDocs(docs map[string]operation) {
+	for name, op := range docs {
+		method, path := splitOperation(name)
+		if !strings.HasPrefix(path, "/api/events") {
+			continue
+		}
+		if !op.hidden {
+			op.summary = strings.TrimSuffix(op.summary, ".") + ", in the given calendar."
+			op.errors = withStatus(op.errors, http.StatusNotFound)
+		}
+		docs[method+" /api/calendars/{calendar}"+strings.TrimPrefix(path, "/api")] = op
+	}
+}
+
+// withStatus returns a copy of codes containing code.
+func withStatus(codes []int, code int) []int {
+	for _, c := range codes {
+		if c == code {
+			return codes
+		}
+	}
+	return append(codes[:len(codes):len(codes)], code)
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCalendarSettings(t *testing.T) {
+	f := setup(t)
+	takeOver := `{"title": "Mine", "admins": ["mallory@example.com"]}`
+
+	// Anonymous users can't take over the default calendar, nor create one.
+	expect(t, serve("PUT", "/api/calendars/default", takeOver), 401)
+	expect(t, serve("POST", "/api/calendars", `{"name": "gophers", "title": "Gophers"}`), 401)
+
+	// Neither can signed in users that aren't administrators.
+	f.User = "mallory@example.com"
+	expect(t, serve("PUT", "/api/calendars/default", takeOver), 403)
+
+	// They can create their own calendar, which they are the admin of.
+	w := serve("POST", "/api/calendars", `{"name": "gophers", "title": "Gophers"}`)
+	expect(t, w, 201)
+	if !strings.Contains(w.Body.String(), `"admins":["mallory@example.com"]`) {
+		t.Errorf("the creator is not the admin: %s", w.Body.String())
+	}
+	expect(t, serve("PUT", "/api/calendars/gophers", `{"title": "Gophers!", "admins": ["mallory@example.com"]}`), 200)
+
+	f.User = "ada@example.com"
+	expect(t, serve("PUT", "/api/calendars/gophers", takeOver), 403)
+
+	// The administrators of the application can change any calendar.
+	f.User, f.Admin = "", true
+	expect(t, serve("PUT", "/api/calendars/default", `{"title": "Events", "admins": ["ada@example.com"]}`), 200)
+	expect(t, serve("PUT", "/api/calendars/gophers", `{"title": "Gophers", "admins": ["ada@example.com"]}`), 200)
+
+	// Including the admins they give the default calendar.
+	f.User, f.Admin = "ada@example.com", false
+	expect(t, serve("PUT", "/api/calendars/default", `{"title": "Events", "admins": ["ada@example.com"]}`), 200)
+
+	// Anybody can still add events to the default calendar while it has no
+	// admins.
+	f.User, f.Admin = "", true
+	expect(t, serve("PUT", "/api/calendars/default", `{"title": "Events"}`), 200)
+	f.Admin = false
+	expect(t, serve("POST", "/api/events", `{"title": "Meetup", "date": "2099-01-01", "location": "Paris"}`), 201)
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+const (
+	monthFormat = "2006-01"
+	// weekFormat is the format of ISO 8601 weeks, such as 2026-W45. Weeks
+	// start on Monday, and the first week of a year has its first Thursday.
+	weekFormat = "%04d-W%02d"
+)
+
+// calendarView contains the events on each day of a month or a week, as
+// shown in a calendar. Events lasting several days are in each of them.
+type calendarView struct {
+	Calendar string `json:"calendar" xml:"calendar,attr"`
+	TimeZone string `json:"time_zone" xml:"time_zone,attr"`
+	// Today is the current date in the time zone of the calendar.
+	Today string        `json:"today" xml:"today,attr"`
+	Days  []calendarDay `json:"days" xml:"day"`
+}
+
+// calendarDay contains the events on a day of a calendar view.
+type calendarDay struct {
+	Date   string  `json:"date" xml:"date,attr"`
+	Events []Event `json:"events" xml:"event"`
+}
+
+func (v *calendarView) xmlDocument() interface{} {
+	return struct {
+		XMLName xml.Name `xml:"calendar"`
+		*calendarView
+	}{calendarView: v}
+}
+
+// vevents returns each event in the view once, even if it lasts several days.
+func (v *calendarView) vevents() []vevent {
+	seen := make(map[string]bool)
+	var events eventList
+	for _, d := range v.Days {
+		for _, e := range d.Events {
+			if path := eventPath(&e); !seen[path] {
+				seen[path] = true
+				events = append(events, e)
+			}
+		}
+	}
+	return events.vevents()
+}
+
+// showCalendarView lists the events on each day of the month or week in the
+// parameters, by default the current month in the time zone of the calendar.
+func showCalendarView(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	c, err := loadCalendar(ctx, calendar)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	today := civilDate(time.Now().In(c.location()))
+	from, to, err := viewPeriod(r.FormValue("month"), r.FormValue("week"), today)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := calendarEvents(ctx, calendar, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	v := &calendarView{Calendar: c.Name, TimeZone: c.TimeZone, Today: today.Format(dateFormat)}
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		v.Days = append(v.Days, calendarDay{Date: day.Format(dateFormat), Events: []Event{}})
+	}
+	for _, e := range events {
+		for day := e.Date; !day.After(e.lastDay()); day = day.AddDate(0, 0, 1) {
+			if !day.Before(from) && day.Before(to) {
+				i := int(day.Sub(from).Hours() / 24)
+				v.Days[i].Events = append(v.Days[i].Events, e)
+			}
+		}
+	}
+	serveEncoded(ctx, w, r, "", time.Time{}, v)
+}
+
+// civilDate returns the date of t, at midnight UTC as the dates of events.
+func civilDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// viewPeriod returns the first day of the month or week given, formatted
+// as monthFormat or weekFormat, and the first day after it. Without any,
+// it's the month of today.
+func viewPeriod(month, week string, today time.Time) (from, to time.Time, err error) {
+	switch {
+	case month != "" && week != "":
+		return from, to, errors.New("month and week can't be used together")
+	case week != "":
+		var year, n int
+		if _, err := fmt.Sscanf(week, "%d-W%d", &year, &n); err != nil || fmt.Sprintf(weekFormat, year, n) != week {
+			return from, to, fmt.Errorf("invalid week %q, use the YYYY-Www format as in 2026-W45", week)
+		}
+		// January 4th is always in the first week.
+		jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+		from = jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7+(n-1)*7)
+		if y, w := from.ISOWeek(); y != year || w != n {
+			return from, to, fmt.Errorf("%d has no week %d", year, n)
+		}
+		return from, from.AddDate(0, 0, 7), nil
+	case month != "":
+		if from, err = time.Parse(monthFormat, month); err != nil {
+			return from, to, fmt.Errorf("invalid month %q, use the YYYY-MM format as in 2026-11", month)
+		}
+	default:
+		from = time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return from, from.AddDate(0, 1, 0), nil
+}
+
+// calendarEvents returns the events in the calendar on any day from the
+// given date and before to, ordered by date.
+func calendarEvents(ctx context.Context, calendar *platform.Key, from, to time.Time) ([]Event, error) {
+	var found []Event
+	// Events that started before from may still be going on.
+	start := time.Now()
+	keys, err := platform.NewQuery(eventKind).
+		Ancestor(calendar).
+		Filter("Date >", from.AddDate(0, 0, -maxEventDays)).
+		Filter("Date <", to).
+		Order("Date").
+		GetAll(ctx, &found)
+	storeDuration.WithLabelValues("calendar events").Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	for i, e := range found {
+		if !e.lastDay().Before(from) {
+			e.setKey(keys[i])
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestViewPeriod(t *testing.T) {
+	today := time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		month, week string
+		from, to    string
+		err         bool
+	}{
+		{"", "", "2026-10-01", "2026-11-01", false},
+		{"2026-02", "", "2026-02-01", "2026-03-01", false},
+		{"2026-12", "", "2026-12-01", "2027-01-01", false},
+		{"2026-13", "", "", "", true},
+		{"2026-11", "2026-W45", "", "", true},
+		{"", "2026-W45", "2026-11-02", "2026-11-09", false},
+		// The first week of a year can start in the previous one.
+		{"", "2026-W01", "2025-12-29", "2026-01-05", false},
+		{"", "2021-W01", "2021-01-04", "2021-01-11", false},
+		// Some years have 53 weeks, and the last one can end in the next year.
+		{"", "2026-W53", "2026-12-28", "2027-01-04", false},
+		{"", "2020-W53", "2020-12-28", "2021-01-04", false},
+		{"", "2027-W53", "", "", true},
+		{"", "2026-W00", "", "", true},
+		{"", "2026-W5", "", "", true},
+		{"", "2026-W045", "", "", true},
+	}
+	for _, tt := range tests {
+		from, to, err := viewPeriod(tt.month, tt.week, today)
+		if tt.err {
+			if err == nil {
+				t.Errorf("month %q and week %q: got %s to %s, want an error", tt.month, tt.week, from.Format(dateFormat), to.Format(dateFormat))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("month %q and week %q: %v", tt.month, tt.week, err)
+			continue
+		}
+		if got, want := from.Format(dateFormat)+" "+to.Format(dateFormat), tt.from+" "+tt.to; got != want {
+			t.Errorf("month %q and week %q: got %s, want %s", tt.month, tt.week, got, want)
+		}
+	}
+}
+
+func TestCalendarViewMultiDay(t *testing.T) {
+	f := setup(t)
+	f.User = "ada@example.com"
+
+	for _, e := range []struct {
+		title, date string
+		days        int
+	}{
+		{"Hackathon", "2098-12-31", 3},
+		{"Meetup", "2099-01-02", 0},
+		{"Conference", "2099-12-30", 4},
+	} {
+		body := fmt.Sprintf(`{"title": %q, "date": %q, "days": %d, "location": "Paris"}`, e.title, e.date, e.days)
+		expect(t, serve("POST", "/api/events", body), 201)
+	}
+	for _, days := range []int{-1, maxEventDays + 1} {
+		body := fmt.Sprintf(`{"title": "Retreat", "date": "2099-01-03", "days": %d, "location": "Paris"}`, days)
+		w := serve("POST", "/api/events", body)
+		expect(t, w, 400)
+		if !strings.Contains(w.Body.String(), "days must be between 0 and") {
+			t.Errorf("got %q for %d days", strings.TrimSpace(w.Body.String()), days)
+		}
+	}
+
+	// view returns the titles of the events on each day with any.
+	view := func(query string) string {
+		w := serve("GET", "/api/events/calendar?"+query, "")
+		expect(t, w, 200)
+		var v calendarView
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			t.Fatalf("could not decode calendar: %v: %s", err, w.Body.String())
+		}
+		var days []string
+		for _, d := range v.Days {
+			if len(d.Events) == 0 {
+				continue
+			}
+			var titles []string
+			for _, e := range d.Events {
+				titles = append(titles, e.Title)
+			}
+			days = append(days, d.Date+" "+strings.Join(titles, ","))
+		}
+		return strings.Join(days, "; ")
+	}
+
+	tests := []struct {
+		query, want string
+	}{
+		// Events that started before the period are still listed.
+		{"month=2099-01", "2099-01-01 Hackathon; 2099-01-02 Hackathon,Meetup"},
+		{"week=2099-W01", "2098-12-31 Hackathon; 2099-01-01 Hackathon; 2099-01-02 Hackathon,Meetup"},
+		{"month=2099-12", "2099-12-30 Conference; 2099-12-31 Conference"},
+		{"week=2099-W53", "2099-12-30 Conference; 2099-12-31 Conference; 2100-01-01 Conference; 2100-01-02 Conference"},
+		{"month=2100-01", "2100-01-01 Conference; 2100-01-02 Conference"},
+		{"week=2100-W01", ""},
+	}
+	for _, tt := range tests {
+		if got := view(tt.query); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
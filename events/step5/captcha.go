@@ -0,0 +1,201 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+const (
+	// captchaHeader contains the response to the CAPTCHA in API requests.
+	captchaHeader = "X-Captcha-Response"
+
+	// stubCaptcha is the CAPTCHA used while developing, which accepts
+	// stubCaptchaResponse and refuses anything else.
+	stubCaptcha         = "stub"
+	stubCaptchaResponse = "pass"
+	// stubCaptchaField is the field of the form with the response to the
+	// stub CAPTCHA.
+	stubCaptchaField = "captcha"
+)
+
+// errCaptchaFailed is returned when the response to a CAPTCHA is not valid.
+var errCaptchaFailed = errors.New("the CAPTCHA was not solved")
+
+// A captchaVerifier checks the response to a CAPTCHA sent by a client.
+type captchaVerifier interface {
+	Verify(ctx context.Context, response, remoteIP string) error
+}
+
+// captchaProvider is a CAPTCHA service. The supported ones verify responses
+// the same way, but have their own widget.
+type captchaProvider struct {
+	verifyURL string
+	// script shows the widget in the element with the class, and sets the
+	// response in the form field.
+	script string
+	class  string
+	field  string
+}
+
+// captchaProviders are the supported CAPTCHA services, by name.
+var captchaProviders = map[string]captchaProvider{
+	"recaptcha": {
+		verifyURL: "https://www.google.com/recaptcha/api/siteverify",
+		script:    "https://www.google.com/recaptcha/api.js",
+		class:     "g-recaptcha",
+		field:     "g-recaptcha-response",
+	},
+	"hcaptcha": {
+		verifyURL: "https://api.hcaptcha.com/siteverify",
+		script:    "https://js.hcaptcha.com/1/api.js",
+		class:     "h-captcha",
+		field:     "h-captcha-response",
+	},
+	"turnstile": {
+		verifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		script:    "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		class:     "cf-turnstile",
+		field:     "cf-turnstile-response",
+	},
+}
+
+// newCaptchaVerifier returns the verifier of the CAPTCHA in the
+// configuration, or nil if there's none.
+func newCaptchaVerifier() captchaVerifier {
+	switch cfg.Captcha {
+	case "":
+		return nil
+	case stubCaptcha:
+		return stubVerifier{}
+	}
+	return &siteVerifier{url: captchaProviders[cfg.Captcha].verifyURL, secret: cfg.CaptchaSecret}
+}
+
+// stubVerifier is a captchaVerifier that needs no service.
+type stubVerifier struct{}
+
+func (stubVerifier) Verify(ctx context.Context, response, remoteIP string) error {
+	if response != stubCaptchaResponse {
+		return errCaptchaFailed
+	}
+	return nil
+}
+
+// siteVerifier verifies responses with the siteverify API of a provider.
+type siteVerifier struct {
+	url    string
+	secret string
+}
+
+func (v *siteVerifier) Verify(ctx context.Context, response, remoteIP string) error {
+	if response == "" {
+		return errCaptchaFailed
+	}
+	res, err := platform.Client(ctx).PostForm(v.url, url.Values{
+		"secret":   {v.secret},
+		"response": {response},
+		"remoteip": {remoteIP},
+	})
+	if err != nil {
+		return fmt.Errorf("could not verify CAPTCHA: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not verify CAPTCHA: %s", res.Status)
+	}
+
+	var data struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return fmt.Errorf("could not decode CAPTCHA verification: %v", err)
+	}
+	if !data.Success {
+		platform.Infof(ctx, "CAPTCHA refused: %s", strings.Join(data.ErrorCodes, ", "))
+		return errCaptchaFailed
+	}
+	return nil
+}
+
+// captchaResponse returns the response to the CAPTCHA sent with the request,
+// in its header or in the form field of the widget.
+func captchaResponse(r *http.Request) string {
+	if response := r.Header.Get(captchaHeader); response != "" {
+		return response
+	}
+	if !isForm(r) {
+		return ""
+	}
+	if cfg.Captcha == stubCaptcha {
+		return r.FormValue(stubCaptchaField)
+	}
+	return r.FormValue(captchaProviders[cfg.Captcha].field)
+}
+
+// checkCaptcha verifies the response to the CAPTCHA of anonymous users, if
+// there's one, writing an error to the response if it's not valid.
+func checkCaptcha(w http.ResponseWriter, r *http.Request) bool {
+	if err := verifyCaptcha(r); err == errCaptchaFailed {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return false
+	}
+	return true
+}
+
+// verifyCaptcha returns an error unless the user making the request is
+// signed in, there's no CAPTCHA, or the response to it is valid.
+func verifyCaptcha(r *http.Request) error {
+	ctx := platform.NewContext(r)
+	v := newCaptchaVerifier()
+	if v == nil || platform.CurrentUser(ctx) != "" {
+		return nil
+	}
+	return v.Verify(ctx, captchaResponse(r), clientIP(r))
+}
+
+// captchaWidget describes the CAPTCHA shown in the events page.
+type captchaWidget struct {
+	// Script, Class and SiteKey are empty for the stub CAPTCHA, which is
+	// a text field.
+	Script  string
+	Class   string
+	SiteKey string
+}
+
+// newCaptchaWidget returns the CAPTCHA to show to the user making the
+// request, or nil if there's none.
+func newCaptchaWidget(r *http.Request) *captchaWidget {
+	if cfg.Captcha == "" || platform.CurrentUser(platform.NewContext(r)) != "" {
+		return nil
+	}
+	if cfg.Captcha == stubCaptcha {
+		return &captchaWidget{}
+	}
+	p := captchaProviders[cfg.Captcha]
+	return &captchaWidget{Script: p.script, Class: p.class, SiteKey: cfg.CaptchaSiteKey}
+}
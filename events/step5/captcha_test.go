@@ -0,0 +1,56 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCaptcha(t *testing.T) {
+	f := setup(t)
+	cfg.Captcha = stubCaptcha
+
+	event := `{"title": "Meetup", "date": "2099-01-01", "location": "Paris"}`
+	expect(t, serve("POST", "/api/events", event), 403)
+	expect(t, serve("POST", "/api/events", event, captchaHeader, "fail"), 403)
+	expect(t, serve("POST", "/api/events", event, captchaHeader, stubCaptchaResponse), 201)
+
+	// Imports are checked the same way.
+	csv := "title,date,location,description\nWorkshop,2099-01-02,Paris,\n"
+	expect(t, serve("POST", "/api/events:import", csv, "Content-Type", "text/csv"), 403)
+	expect(t, serve("POST", "/api/events:import", csv, "Content-Type", "text/csv", captchaHeader, stubCaptchaResponse), 200)
+
+	// And so is the addEvent mutation, but not the queries.
+	mutation := `{"query": "mutation { addEvent(input: {title: \"Party\", date: \"2099-01-03\", location: \"Paris\"}) { title } }"}`
+	w := serve("POST", "/api/graphql", mutation)
+	expect(t, w, 200)
+	if !strings.Contains(w.Body.String(), errCaptchaFailed.Error()) {
+		t.Errorf("got %s, want the CAPTCHA to fail", w.Body.String())
+	}
+	w = serve("POST", "/api/graphql", `{"query": "{ events { edges { node { title } } } }"}`)
+	expect(t, w, 200)
+	if got := w.Body.String(); strings.Contains(got, "errors") || !strings.Contains(got, "Workshop") {
+		t.Errorf("got %s, want the events", got)
+	}
+	w = serve("POST", "/api/graphql", mutation, captchaHeader, stubCaptchaResponse)
+	expect(t, w, 200)
+	if got := w.Body.String(); got != `{"data":{"addEvent":{"title":"Party"}}}` {
+		t.Errorf("got %s, want the event to be added", got)
+	}
+
+	// Signed in users don't need to solve it.
+	f.User = "ada@example.com"
+	expect(t, serve("POST", "/api/events", `{"title": "Talk", "date": "2099-01-04", "location": "Paris"}`), 201)
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+// +build !appengine
+
+// The events command runs the events application as a plain Go program,
+// serving the same handlers and static files as app.yaml does on App Engine.
+// Run it from the directory containing app.yaml:
+//
+//	GOOGLE_CLOUD_PROJECT=my-project go run ./cmd/events -weather-api-key=<key>
+//
+// The flags are described in README.md, or listed with -help.
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/campoy/go-web-workshop/platform"
+
+	// The events package registers its handlers on http.DefaultServeMux.
+	"github.com/campoy/go-web-workshop/events/step5"
+)
+
+// appPaths are the paths outside /api/ handled by the application, the rest
+// are static files.
+var appPaths = map[string]bool{"/": true, "/metrics": true, "/healthz": true, "/readyz": true}
+
+// adminPaths are the paths restricted to administrators in app.yaml.
+var adminPaths = []string{"/api/cron/", "/api/tasks/", "/api/webhooks", "/api/admin/", "/metrics"}
+
+func main() {
+	if err := events.Configure(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(2)
+	} else if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	static, err := events.StaticHandler("static")
+	if err != nil {
+		log.Fatal(err)
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin := isAdmin(r)
+		if admin {
+			// The events package checks it with platform.IsAdmin.
+			r = r.WithContext(platform.WithAdmin(r.Context()))
+		}
+		switch {
+		case isAdminPath(r.URL.Path):
+			if !admin {
+				http.Error(w, "admin token required", http.StatusUnauthorized)
+				return
+			}
+			http.DefaultServeMux.ServeHTTP(w, r)
+		case appPaths[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/api/"):
+			http.DefaultServeMux.ServeHTTP(w, r)
+		default:
+			static.ServeHTTP(w, r)
+		}
+	})
+
+	if err := platform.ListenAndServe(events.Port(), h); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func isAdminPath(path string) bool {
+	for _, p := range adminPaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdmin returns whether the request has the admin token in the
+// configuration as a bearer token. Without an admin token nobody is an
+// administrator.
+func isAdmin(r *http.Request) bool {
+	token := events.AdminToken()
+	if token == "" {
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,201 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// minCompressSize is the size under which responses are not worth
+// compressing, when their size is known.
+const minCompressSize = 1024
+
+// compressibleTypes are the media types of the responses that are
+// compressed, besides the text ones.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/x-ndjson":   true,
+	"application/javascript": true,
+}
+
+// acceptedEncoding returns the content coding to use for a response to a
+// request with the given Accept-Encoding header: br, gzip or "" for none.
+func acceptedEncoding(header string) string {
+	var gz bool
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := mime.ParseMediaType(strings.TrimSpace(part))
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		switch coding {
+		case "br":
+			return "br"
+		case "gzip":
+			gz = true
+		}
+	}
+	if gz {
+		return "gzip"
+	}
+	return ""
+}
+
+// compress compresses the responses of h with brotli or gzip, as accepted by
+// the client, when their content type is compressible.
+//
+// Compressed responses get the entity tag set by h with the content coding
+// added, as static files do, since their bytes differ. The content coding is
+// removed from the entity tags in the If-None-Match and If-Match headers, so
+// h compares them with its own.
+func compress(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		ifNoneMatch := r.Header.Get("If-None-Match")
+		for _, name := range []string{"If-None-Match", "If-Match"} {
+			if v := r.Header.Get(name); v != "" {
+				r.Header.Set(name, stripCodings(v))
+			}
+		}
+
+		coding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if coding == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, coding: coding, ifNoneMatch: ifNoneMatch}
+		defer cw.Close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// contentCodings are the content codings added to entity tags.
+var contentCodings = []string{"br", "gzip"}
+
+// withCoding returns the entity tag of the response compressed with coding.
+func withCoding(etag, coding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + coding + `"`
+}
+
+// stripCoding returns the entity tag without the content coding added by
+// withCoding, and the coding, empty if there's none.
+func stripCoding(etag string) (string, string) {
+	for _, coding := range contentCodings {
+		if suffix := "-" + coding + `"`; strings.HasSuffix(etag, suffix) {
+			return strings.TrimSuffix(etag, suffix) + `"`, coding
+		}
+	}
+	return etag, ""
+}
+
+// stripCodings removes the content codings from the entity tags in the value
+// of an If-None-Match or If-Match header.
+func stripCodings(header string) string {
+	tags := strings.Split(header, ",")
+	for i, tag := range tags {
+		tags[i], _ = stripCoding(strings.TrimSpace(tag))
+	}
+	return strings.Join(tags, ", ")
+}
+
+// compressWriter is an http.ResponseWriter compressing the body, once it
+// knows from the headers that it's worth it.
+type compressWriter struct {
+	http.ResponseWriter
+	coding string
+	// ifNoneMatch is the If-None-Match header of the request, with the
+	// content codings in its entity tags.
+	ifNoneMatch string
+	wroteHeader bool
+	// enc compresses the body, nil if it's written as is.
+	enc io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	etag := h.Get("ETag")
+	if w.shouldCompress(status) {
+		h.Set("Content-Encoding", w.coding)
+		h.Del("Content-Length")
+		// Ranges are of the uncompressed body, so they can't be requested
+		// for the compressed one.
+		h.Del("Accept-Ranges")
+		if etag != "" {
+			h.Set("ETag", withCoding(etag, w.coding))
+		}
+		if w.coding == "br" {
+			w.enc = brotli.NewWriterLevel(w.ResponseWriter, 5)
+		} else {
+			w.enc = gzip.NewWriter(w.ResponseWriter)
+		}
+	} else if status == http.StatusNotModified && etag != "" {
+		// The client has the variant whose tag it sent, compared weakly
+		// as If-None-Match is.
+		for _, tag := range strings.Split(w.ifNoneMatch, ",") {
+			tag, coding := stripCoding(strings.TrimSpace(tag))
+			if coding != "" && strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				h.Set("ETag", withCoding(etag, coding))
+				break
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) shouldCompress(status int) bool {
+	h := w.Header()
+	// Partial content is a range of the uncompressed body.
+	if status < 200 || status == http.StatusNoContent || status == http.StatusPartialContent ||
+		status == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minCompressSize {
+		return false
+	}
+	t, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return strings.HasPrefix(t, "text/") || compressibleTypes[t]
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.enc.Write(b)
+}
+
+// Close flushes the compressed body, if any.
+func (w *compressWriter) Close() error {
+	if w.enc == nil {
+		return nil
+	}
+	return w.enc.Close()
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressETag(t *testing.T) {
+	body := strings.Repeat(`{"title": "Meetup"}`, 100)
+	var ifMatch string
+	h := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifMatch = r.Header.Get("If-Match")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte(body)))
+	}))
+	get := func(headers ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, tt := range []struct {
+		headers    []string
+		status     int
+		coding     string
+		etag       string
+		ifMatchArg string
+	}{
+		{nil, 200, "", `"abc"`, ""},
+		{[]string{"Accept-Encoding", "gzip"}, 200, "gzip", `"abc-gzip"`, ""},
+		{[]string{"Accept-Encoding", "br, gzip"}, 200, "br", `"abc-br"`, ""},
+		// The tag of each variant is revalidated as such.
+		{[]string{"Accept-Encoding", "gzip", "If-None-Match", `"abc-gzip"`}, 304, "", `"abc-gzip"`, ""},
+		{[]string{"Accept-Encoding", "br", "If-None-Match", `"old", W/"abc-br"`}, 304, "", `"abc-br"`, ""},
+		{[]string{"If-None-Match", `"abc"`}, 304, "", `"abc"`, ""},
+		{[]string{"Accept-Encoding", "gzip", "If-None-Match", `"old-gzip"`}, 200, "gzip", `"abc-gzip"`, ""},
+		// Handlers get the tags they set.
+		{[]string{"Accept-Encoding", "gzip", "If-Match", `"abc-gzip", "def-br"`}, 200, "gzip", `"abc-gzip"`, `"abc", "def"`},
+	} {
+		w := get(tt.headers...)
+		if w.Code != tt.status || w.Header().Get("Content-Encoding") != tt.coding || w.Header().Get("ETag") != tt.etag {
+			t.Errorf("with headers %q got status %d, coding %q and ETag %s, want %d, %q and %s", tt.headers,
+				w.Code, w.Header().Get("Content-Encoding"), w.Header().Get("ETag"), tt.status, tt.coding, tt.etag)
+		}
+		if ifMatch != tt.ifMatchArg {
+			t.Errorf("with headers %q the handler got If-Match %s, want %s", tt.headers, ifMatch, tt.ifMatchArg)
+		}
+	}
+
+	// Compressed responses don't accept ranges, and ranges are served
+	// uncompressed with the tag of the uncompressed body.
+	if w := get("Accept-Encoding", "gzip"); w.Header().Get("Accept-Ranges") != "" {
+		t.Errorf("a compressed response has Accept-Ranges %q", w.Header().Get("Accept-Ranges"))
+	}
+	if w := get(); w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("an uncompressed response has Accept-Ranges %q, want bytes", w.Header().Get("Accept-Ranges"))
+	}
+	w := get("Accept-Encoding", "gzip", "Range", "bytes=0-9")
+	if w.Code != 206 || w.Header().Get("Content-Encoding") != "" || w.Header().Get("ETag") != `"abc"` || w.Body.String() != body[:10] {
+		t.Errorf("a range got status %d, coding %q, ETag %s and body %q", w.Code, w.Header().Get("Content-Encoding"), w.Header().Get("ETag"), w.Body)
+	}
+	// A range of the compressed variant is not served.
+	w = get("Accept-Encoding", "gzip", "Range", "bytes=0-9", "If-Range", `"abc-gzip"`)
+	if w.Code != 200 || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("a range of the compressed body got status %d and coding %q, want 200 and gzip", w.Code, w.Header().Get("Content-Encoding"))
+	}
+}
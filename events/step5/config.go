@@ -0,0 +1,284 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+// placeholderAPIKey is the weather API key shipped in app.yaml, which must
+// be replaced by a real one.
+const placeholderAPIKey = "get your own!"
+
+// config is the configuration of the application.
+type config struct {
+	WeatherAPIKey   string
+	WeatherDisabled bool
+	BlockWrites     bool
+	// ModerateSubmissions holds the events added by anonymous users until
+	// a moderator approves them.
+	ModerateSubmissions bool
+	MailFrom            string
+	SMTPAddr            string
+	SMTPUsername        string
+	SMTPPassword        string
+	// RateLimit is the number of requests changing data allowed per minute
+	// to each client, zero for no limit.
+	RateLimit      int
+	APIKeys        string
+	Captcha        string
+	CaptchaSiteKey string
+	CaptchaSecret  string
+	// TracesExporter is the OpenTelemetry exporter of traces, and
+	// OTLPEndpoint the URL of the collector for the otlp one, see
+	// platform.SetupTracing.
+	TracesExporter string
+	OTLPEndpoint   string
+	// AdminToken and Port are only used outside App Engine, by the events
+	// command.
+	AdminToken string
+	Port       int
+}
+
+// Defaults of the settings unless others are configured.
+const (
+	defaultRateLimit = 30
+	defaultPort      = 8080
+)
+
+// cfg is the configuration in use, set by Configure.
+var cfg config
+
+// setting describes how a field of config is set: the flag and the key in
+// the configuration file share the same name.
+type setting struct {
+	name   string
+	env    string
+	usage  string
+	secret bool
+	// value points to the field of config, a *string, a *bool or an *int.
+	value interface{}
+}
+
+func (c *config) settings() []setting {
+	return []setting{
+		{"weather-api-key", "WEATHER_API_KEY", "key for the openweathermap.org API", true, &c.WeatherAPIKey},
+		{"weather-disabled", "WEATHER_DISABLED", "don't fetch the weather of events", false, &c.WeatherDisabled},
+		{"block-writes", "BLOCK_WRITES", "make this instance read only", false, &c.BlockWrites},
+		{"moderate-submissions", "MODERATE_SUBMISSIONS", "hold the events added by anonymous users for moderation", false, &c.ModerateSubmissions},
+		{"mail-from", "MAIL_FROM", "sender of emails, events@<app id>.appspotmail.com by default", false, &c.MailFrom},
+		{"smtp-addr", "SMTP_ADDR", "address of the SMTP server sending emails, instead of the default sender", false, &c.SMTPAddr},
+		{"smtp-username", "SMTP_USERNAME", "user name for the SMTP server", false, &c.SMTPUsername},
+		{"smtp-password", "SMTP_PASSWORD", "password for the SMTP server", true, &c.SMTPPassword},
+		{"rate-limit", "RATE_LIMIT", "requests changing data allowed per minute to each client, 0 for no limit", false, &c.RateLimit},
+		{"api-keys", "API_KEYS", "comma separated keys sent by clients in the X-API-Key header, each rate limited on its own", true, &c.APIKeys},
+		{"captcha", "CAPTCHA", "CAPTCHA checking anonymous users adding events: recaptcha, hcaptcha, turnstile or stub", false, &c.Captcha},
+		{"captcha-site-key", "CAPTCHA_SITE_KEY", "site key of the CAPTCHA widget", false, &c.CaptchaSiteKey},
+		{"captcha-secret", "CAPTCHA_SECRET", "secret key verifying CAPTCHA responses", true, &c.CaptchaSecret},
+		{"traces-exporter", "OTEL_TRACES_EXPORTER", "exporter of traces: none, console or otlp", false, &c.TracesExporter},
+		{"otlp-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "URL of the OpenTelemetry collector receiving the traces, http://localhost:4318 by default", false, &c.OTLPEndpoint},
+		{"admin-token", "ADMIN_TOKEN", "bearer token of administrators, outside App Engine", true, &c.AdminToken},
+		{"port", "PORT", "port to listen on, outside App Engine", false, &c.Port},
+	}
+}
+
+func (s setting) set(v string) error {
+	switch p := s.value.(type) {
+	case *string:
+		*p = v
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", s.name, v)
+		}
+		*p = b
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%s: %q is not a positive number", s.name, v)
+		}
+		*p = n
+	}
+	return nil
+}
+
+// Configure loads the configuration of the application and validates it,
+// and sets up tracing. Settings are read, in increasing order of precedence,
+// from the JSON file in the -config flag or $CONFIG_FILE, the environment and
+// the flags in args. On App Engine it's called when the package is
+// initialized, without flags.
+func Configure(args []string) error {
+	c, err := loadConfig(args, os.Getenv)
+	if err != nil {
+		return err
+	}
+	cfg = *c
+	return platform.SetupTracing(serviceName, cfg.TracesExporter, cfg.OTLPEndpoint)
+}
+
+// AdminToken returns the bearer token identifying administrators outside
+// App Engine, or "" if there's none.
+func AdminToken() string { return cfg.AdminToken }
+
+// Port returns the port to listen on outside App Engine.
+func Port() int { return cfg.Port }
+
+func loadConfig(args []string, getenv func(string) string) (*config, error) {
+	c := &config{RateLimit: defaultRateLimit, Port: defaultPort}
+	settings := c.settingsByName()
+
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	file := fs.String("config", getenv("CONFIG_FILE"), "JSON configuration file, with the flags as keys ($CONFIG_FILE)")
+	for _, s := range c.settings() {
+		usage := fmt.Sprintf("%s ($%s)", s.usage, s.env)
+		if _, ok := s.value.(*bool); ok {
+			fs.Bool(s.name, false, usage)
+		} else {
+			fs.String(s.name, "", usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		if err := c.loadFile(*file); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(v); err != nil {
+				return nil, fmt.Errorf("$%s: %v", s.env, err)
+			}
+		}
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if s, ok := settings[f.Name]; ok && err == nil {
+			err = s.set(f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, c.validate()
+}
+
+func (c *config) settingsByName() map[string]setting {
+	settings := make(map[string]setting)
+	for _, s := range c.settings() {
+		settings[s.name] = s
+	}
+	return settings
+}
+
+// loadFile reads the settings in the JSON file at path.
+func (c *config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open config: %v", err)
+	}
+	defer f.Close()
+
+	// Numbers are decoded as json.Number, which prints them as written
+	// instead of 1e+06 for a million.
+	var values map[string]interface{}
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("could not decode config %s: %v", path, err)
+	}
+	settings := c.settingsByName()
+	for name, v := range values {
+		s, ok := settings[name]
+		if !ok {
+			return fmt.Errorf("config %s: unknown setting %q", path, name)
+		}
+		if err := s.set(fmt.Sprint(v)); err != nil {
+			return fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// validate returns an error describing what's wrong with the configuration.
+func (c *config) validate() error {
+	if !c.WeatherDisabled {
+		switch c.WeatherAPIKey {
+		case "":
+			return errors.New("missing weather API key, set $WEATHER_API_KEY or $WEATHER_DISABLED")
+		case placeholderAPIKey:
+			return errors.New("the weather API key is the placeholder in app.yaml, replace it with your own")
+		}
+	}
+	if c.SMTPPassword != "" && c.SMTPUsername == "" {
+		return errors.New("SMTP password given without a user name")
+	}
+	if c.Captcha != "" && c.Captcha != stubCaptcha {
+		if _, ok := captchaProviders[c.Captcha]; !ok {
+			return fmt.Errorf("unknown CAPTCHA %q", c.Captcha)
+		}
+		if c.CaptchaSiteKey == "" || c.CaptchaSecret == "" {
+			return fmt.Errorf("the %s CAPTCHA needs a site key and a secret", c.Captcha)
+		}
+	}
+	if c.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.Port)
+	}
+	if c.OTLPEndpoint != "" {
+		u, err := url.Parse(c.OTLPEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("the OTLP endpoint %q is not an http or https URL", c.OTLPEndpoint)
+		}
+	}
+	return platform.CheckTracesExporter(c.TracesExporter)
+}
+
+// redacted returns the settings in the configuration, by name, with the
+// value of secrets hidden.
+func (c *config) redacted() map[string]interface{} {
+	res := make(map[string]interface{})
+	for _, s := range c.settings() {
+		var v interface{}
+		switch p := s.value.(type) {
+		case *string:
+			v = *p
+			if s.secret && *p != "" {
+				v = "REDACTED"
+			}
+		case *bool:
+			v = *p
+		case *int:
+			v = *p
+		}
+		res[s.name] = v
+	}
+	return res
+}
+
+// showConfig writes the configuration in use, without secrets.
+func showConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(platform.NewContext(r), w, http.StatusOK, cfg.redacted())
+}
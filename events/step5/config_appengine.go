@@ -0,0 +1,25 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build appengine
+// +build appengine
+
+package events
+
+// On App Engine there's no main function, so the configuration is loaded
+// from app.yaml when the instance starts, failing if it's not valid.
+func init() {
+	if err := Configure(nil); err != nil {
+		panic(err)
+	}
+}
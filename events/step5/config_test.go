@@ -0,0 +1,96 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// env returns a getenv function reading the given variables.
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestConfigTracesExporter(t *testing.T) {
+	for _, tt := range []struct {
+		exporter string
+		err      string
+	}{
+		{"", ""},
+		{"none", ""},
+		{"console", ""},
+		{"otlp", ""},
+		{"jaeger", `unknown traces exporter "jaeger"`},
+	} {
+		c, err := loadConfig(nil, env(map[string]string{"WEATHER_DISABLED": "true", "OTEL_TRACES_EXPORTER": tt.exporter}))
+		if tt.err == "" && (err != nil || c.TracesExporter != tt.exporter) {
+			t.Errorf("with exporter %q got %v, want it to be valid", tt.exporter, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("with exporter %q got error %v, want %q", tt.exporter, err, tt.err)
+		}
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"weather-disabled": true, "rate-limit": 1000000, "port": 9090}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RateLimit != 1000000 || c.Port != 9090 {
+		t.Errorf("got rate limit %d and port %d, want 1000000 and 9090", c.RateLimit, c.Port)
+	}
+}
+
+func TestConfigServer(t *testing.T) {
+	c, err := loadConfig(nil, env(map[string]string{"WEATHER_DISABLED": "true"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != defaultPort || c.AdminToken != "" || c.OTLPEndpoint != "" {
+		t.Errorf("got port %d, admin token %q and OTLP endpoint %q, want the defaults", c.Port, c.AdminToken, c.OTLPEndpoint)
+	}
+
+	c, err = loadConfig([]string{"-port", "9090"}, env(map[string]string{
+		"WEATHER_DISABLED":            "true",
+		"ADMIN_TOKEN":                 "secret",
+		"PORT":                        "8000",
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 9090 || c.AdminToken != "secret" || c.OTLPEndpoint != "http://collector:4318" {
+		t.Errorf("got port %d, admin token %q and OTLP endpoint %q", c.Port, c.AdminToken, c.OTLPEndpoint)
+	}
+	if v := c.redacted()["admin-token"]; v != "REDACTED" {
+		t.Errorf("the admin token is shown as %v", v)
+	}
+
+	for name, v := range map[string]string{
+		"PORT":                        "70000",
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "collector:4318",
+	} {
+		if _, err := loadConfig(nil, env(map[string]string{"WEATHER_DISABLED": "true", name: v})); err == nil {
+			t.Errorf("with $%s=%s got no error", name, v)
+		}
+	}
+}
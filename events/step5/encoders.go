@@ -0,0 +1,289 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An encoder writes responses in a media type.
+type encoder struct {
+	// format is the name of the encoder in the format parameter.
+	format string
+	// mediaTypes are the ones accepted for the encoder, the first one is
+	// the Content-Type of the responses.
+	mediaTypes []string
+	// supports returns whether v can be encoded, nil if any value can.
+	supports func(v interface{}) bool
+	encode   func(w io.Writer, v interface{}) error
+}
+
+// encoders are the encoders available to negotiate, in order of preference.
+var encoders = []*encoder{
+	{"json", []string{"application/json"}, nil, encodeJSON},
+	{"xml", []string{"application/xml", "text/xml"}, nil, encodeXML},
+	{"ndjson", []string{"application/x-ndjson", "application/ndjson"}, nil, encodeNDJSON},
+	{"csv", []string{"text/csv"}, isTable, encodeCSV},
+	{"ics", []string{"text/calendar"}, isICalendar, encodeICalendar},
+}
+
+// Errors returned by negotiate.
+var (
+	errUnknownFormat = errors.New("unknown format")
+	errNotAcceptable = errors.New("none of the accepted media types is available")
+)
+
+// negotiate returns the encoder for v requested with the format parameter or,
+// if there's none, preferred by the Accept header of the request.
+func negotiate(r *http.Request, v interface{}) (*encoder, error) {
+	if name := r.FormValue("format"); name != "" {
+		for _, e := range encoders {
+			if e.format == name {
+				if e.supports != nil && !e.supports(v) {
+					return nil, errNotAcceptable
+				}
+				return e, nil
+			}
+		}
+		return nil, errUnknownFormat
+	}
+
+	for _, mediaRange := range acceptedRanges(r.Header.Get("Accept")) {
+		for _, e := range encoders {
+			if (e.supports == nil || e.supports(v)) && e.matches(mediaRange) {
+				return e, nil
+			}
+		}
+	}
+	return nil, errNotAcceptable
+}
+
+// matches returns whether the encoder writes a media type in the range,
+// such as text/csv, text/* or */*.
+func (e *encoder) matches(mediaRange string) bool {
+	for _, t := range e.mediaTypes {
+		if mediaRange == "*/*" || mediaRange == t ||
+			strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(t, strings.TrimSuffix(mediaRange, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedRanges returns the media ranges in an Accept header, ordered by
+// their quality. An empty header accepts everything.
+func acceptedRanges(accept string) []string {
+	if strings.TrimSpace(accept) == "" {
+		return []string{"*/*"}
+	}
+	type accepted struct {
+		mediaRange string
+		q          float64
+	}
+	var ranges []accepted
+	for _, part := range strings.Split(accept, ",") {
+		t, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, accepted{t, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	res := make([]string, len(ranges))
+	for i, a := range ranges {
+		res[i] = a.mediaRange
+	}
+	return res
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// encodeNDJSON writes each element of a slice as a JSON line, and any other
+// value as a single line.
+func encodeNDJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return enc.Encode(v)
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xmlDocument is implemented by values that need a root element to be
+// encoded as XML, such as slices.
+type xmlDocument interface {
+	xmlDocument() interface{}
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	if d, ok := v.(xmlDocument); ok {
+		v = d.xmlDocument()
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// table is implemented by values that can be encoded as CSV.
+type table interface {
+	header() []string
+	rows() [][]string
+}
+
+func isTable(v interface{}) bool {
+	_, ok := v.(table)
+	return ok
+}
+
+func encodeCSV(w io.Writer, v interface{}) error {
+	t := v.(table)
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.header()); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(t.rows()); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// icalendar is implemented by values that can be encoded as an iCalendar
+// document, as defined in RFC 5545.
+type icalendar interface {
+	vevents() []vevent
+}
+
+// vevent contains the properties of an iCalendar event.
+type vevent struct {
+	uid         string
+	stamp       time.Time
+	date        time.Time
+	days        int
+	summary     string
+	location    string
+	description string
+	// geo is nil if the event has no coordinates.
+	geo *point
+}
+
+func isICalendar(v interface{}) bool {
+	_, ok := v.(icalendar)
+	return ok
+}
+
+func encodeICalendar(w io.Writer, v interface{}) error {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//go-web-workshop//events//EN"}
+	for _, e := range v.(icalendar).vevents() {
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+icalText(e.uid),
+			"DTSTAMP:"+e.stamp.UTC().Format("20060102T150405Z"),
+			"DTSTART;VALUE=DATE:"+e.date.Format("20060102"),
+		)
+		if e.days > 1 {
+			// The end of events is exclusive.
+			lines = append(lines, "DTEND;VALUE=DATE:"+e.date.AddDate(0, 0, e.days).Format("20060102"))
+		}
+		lines = append(lines,
+			"SUMMARY:"+icalText(e.summary),
+			"LOCATION:"+icalText(e.location),
+		)
+		if e.description != "" {
+			lines = append(lines, "DESCRIPTION:"+icalText(e.description))
+		}
+		if e.geo != nil {
+			lines = append(lines, fmt.Sprintf("GEO:%s;%s", formatCoordinate(&e.geo.lat), formatCoordinate(&e.geo.lon)))
+		}
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, l := range lines {
+		if _, err := io.WriteString(w, icalFold(l)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// icalEscaper escapes the characters with a special meaning in iCalendar
+// text values.
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func icalText(s string) string { return icalEscaper.Replace(s) }
+
+// icalFold splits lines longer than 75 bytes, continuing them in lines
+// starting with a space, without splitting UTF-8 characters.
+func icalFold(line string) string {
+	var b strings.Builder
+	for n := 75; len(line) > n; n = 74 {
+		i := n
+		for i > 0 && line[i]&0xC0 == 0x80 {
+			i--
+		}
+		b.WriteString(line[:i])
+		b.WriteString("\r\n ")
+		line = line[i:]
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// negotiationError writes the error returned by negotiate to the response.
+func negotiationError(w http.ResponseWriter, err error) {
+	if err == errUnknownFormat {
+		var names []string
+		for _, e := range encoders {
+			names = append(names, e.format)
+		}
+		http.Error(w, fmt.Sprintf("unknown format, use one of %s", strings.Join(names, ", ")), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusNotAcceptable)
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// errPreconditionFailed is returned when the If-Match header of a request
+// doesn't match the current version of an event.
+var errPreconditionFailed = errors.New("the event was modified, fetch it again and retry")
+
+// serveEncoded encodes v as the body of the response in the format chosen by
+// negotiate, with the given entity tag and modification time. If the etag is
+// empty it's computed from the body, otherwise it identifies the JSON
+// representation and the format is added for the others.
+// Conditional requests are answered with 304 Not Modified when appropriate.
+// Lists of events are served with a zero modtime, so without Last-Modified,
+// since deleted or past events change them without changing the time any
+// of the listed events was updated: they're only revalidated by their ETag.
+func serveEncoded(ctx context.Context, w http.ResponseWriter, r *http.Request, etag string, modtime time.Time, v interface{}) {
+	w.Header().Add("Vary", "Accept")
+	enc, err := negotiate(r, v)
+	if err != nil {
+		negotiationError(w, err)
+		return
+	}
+
+	var b bytes.Buffer
+	if err := enc.encode(&b, v); err != nil {
+		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if etag == "" {
+		etag = fmt.Sprintf(`"%x"`, sha256.Sum256(b.Bytes()))
+	} else if enc.format != "json" {
+		etag = strings.TrimSuffix(etag, `"`) + "-" + enc.format + `"`
+	}
+
+	w.Header().Set("Content-Type", enc.mediaTypes[0])
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", modtime, bytes.NewReader(b.Bytes()))
+}
+
+// eventETag returns the entity tag identifying the stored version of e.
+// The weather is not part of the event so it's ignored.
+func eventETag(e *Event) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q %q %d", e.Title, e.Date.Format(time.RFC3339), e.Location, e.Description, e.Updated.UnixNano())
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+}
+
+// requireIfMatch checks the request has an If-Match header, writing an error
+// to the response if it doesn't. Requiring it prevents clients from
+// overwriting changes they haven't seen.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("If-Match") == "" {
+		http.Error(w, "the If-Match header with the ETag of the event is required", http.StatusPreconditionRequired)
+		return false
+	}
+	return true
+}
+
+// checkIfMatch returns errPreconditionFailed unless the If-Match header of
+// the request matches the current version of the event.
+func checkIfMatch(r *http.Request, e *Event) error {
+	current := eventETag(e)
+	for _, tag := range strings.Split(r.Header.Get("If-Match"), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return nil
+		}
+	}
+	return errPreconditionFailed
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListNotModified(t *testing.T) {
+	f := setup(t)
+	f.User = "ada@example.com"
+
+	w := serve("POST", "/api/events", eventJSON("Meetup", 1))
+	expect(t, w, 201)
+	location := w.Header().Get("Location")
+	expect(t, serve("POST", "/api/events", eventJSON("Party", 2)), 201)
+
+	w = serve("GET", "/api/events", "")
+	expect(t, w, 200)
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Last-Modified") != "" {
+		t.Fatalf("got ETag %q and Last-Modified %q, want only an ETag", etag, w.Header().Get("Last-Modified"))
+	}
+	expect(t, serve("GET", "/api/events", "", "If-None-Match", etag), 304)
+
+	// Deleting an event changes the list, but not when the listed events
+	// were updated.
+	w = serve("GET", location, "")
+	expect(t, w, 200)
+	if w.Header().Get("Last-Modified") == "" {
+		t.Errorf("a single event has no Last-Modified")
+	}
+	expect(t, serve("DELETE", location, "", "If-Match", w.Header().Get("ETag")), 204)
+
+	since := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	w = serve("GET", "/api/events", "", "If-None-Match", etag, "If-Modified-Since", since)
+	expect(t, w, 200)
+	if got, want := titles(t, w), "Party"; got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+	w = serve("GET", "/api/events", "", "If-Modified-Since", since)
+	expect(t, w, 200)
+}
+
+func TestEventPreconditions(t *testing.T) {
+	f := setup(t)
+	f.User = "ada@example.com"
+
+	w := serve("POST", "/api/events", eventJSON("Meetup", 1))
+	expect(t, w, 201)
+	location := w.Header().Get("Location")
+	w = serve("GET", location, "")
+	expect(t, w, 200)
+	etag := w.Header().Get("ETag")
+	expect(t, serve("GET", location, "", "If-None-Match", etag), 304)
+
+	// Changes need the ETag of the current version of the event.
+	expect(t, serve("PUT", location, eventJSON("Meetup", 2)), 428)
+	expect(t, serve("PUT", location, eventJSON("Meetup", 2), "If-Match", `"other"`), 412)
+	w = serve("PUT", location, eventJSON("Meetup", 2), "If-Match", `"other", `+etag)
+	expect(t, w, 200)
+	updated := w.Header().Get("ETag")
+	if updated == "" || updated == etag {
+		t.Fatalf("got ETag %q after the update, want a new one", updated)
+	}
+	expect(t, serve("GET", location, "", "If-None-Match", etag), 200)
+	expect(t, serve("GET", location, "", "If-None-Match", updated), 304)
+
+	// The first change wins, the second one was based on the old version.
+	expect(t, serve("PUT", location, eventJSON("Party", 2), "If-Match", etag), 412)
+	expect(t, serve("DELETE", location, ""), 428)
+	expect(t, serve("DELETE", location, "", "If-Match", etag), 412)
+	w = serve("GET", location, "")
+	expect(t, w, 200)
+	if !strings.Contains(w.Body.String(), `"title":"Meetup"`) {
+		t.Errorf("the event was changed with an old ETag: %s", w.Body.String())
+	}
+	expect(t, serve("DELETE", location, "", "If-Match", "*"), 204)
+	expect(t, serve("GET", location, ""), 404)
+}
@@ -15,76 +15,393 @@ package events
 
 import (
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	eventKind = "Event"
+
+	// dateFormat is the format used by clients to send dates.
+	dateFormat = "2006-01-02"
+
+	// maxEventDays is the number of days the longest events last.
+	maxEventDays = 31
 )
 
-const eventKind = "Event"
+// errDuplicateEvent is returned when creating an event with the same title,
+// date and location as an existing one, usually sent twice by mistake.
+var errDuplicateEvent = errors.New("an event with the same title, date and location already exists")
 
 // Event contains the information related to an event.
 type Event struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Date        time.Time `json:"date"`
-	Location    string    `json:"location"`
-	Weather     *Weather  `json:"weather" datastore:"-"`
+	ID          int64     `json:"id" xml:"id,attr" datastore:"-"`
+	Calendar    string    `json:"calendar" xml:"calendar,attr" datastore:"-"`
+	Title       string    `json:"title" xml:"title"`
+	Description string    `json:"description" xml:"description"`
+	Date        time.Time `json:"date" xml:"date"`
+	Location    string    `json:"location" xml:"location"`
+	Updated     time.Time `json:"updated" xml:"updated"`
+	Weather     *Weather  `json:"weather" xml:"weather,omitempty" datastore:"-"`
+	// Days is the number of days the event lasts from Date, zero for events
+	// lasting a single day.
+	Days int `json:"days,omitempty" xml:"days,omitempty" datastore:",noindex"`
+	// Latitude and Longitude are the optional coordinates of the event, in
+	// degrees. Geohash encodes them, and is empty if there are none, and
+	// GeohashPrefixes indexes them, see geohashPrefixes.
+	Latitude        float64  `json:"latitude,omitempty" xml:"latitude,omitempty" datastore:",noindex"`
+	Longitude       float64  `json:"longitude,omitempty" xml:"longitude,omitempty" datastore:",noindex"`
+	Geohash         string   `json:"-" xml:"-" datastore:",noindex"`
+	GeohashPrefixes []string `json:"-" xml:"-"`
+	// Distance is the distance in kilometers to the point in the near
+	// parameter of the request, if any.
+	Distance *float64 `json:"distance,omitempty" xml:"distance,omitempty" datastore:"-"`
 }
 
 // Weather contains the description and icon for a weather condition.
 type Weather struct {
-	Description string `json:"description"`
-	Icon        string `json:"icon"`
+	Description string `json:"description" xml:"description"`
+	Icon        string `json:"icon" xml:"icon"`
 }
 
+// eventList is a list of events, as served by listEvents in each of the
+// formats of the encoders.
+type eventList []Event
+
+func (l eventList) xmlDocument() interface{} {
+	return struct {
+		XMLName xml.Name `xml:"events"`
+		Events  []Event  `xml:"event"`
+	}{Events: l}
+}
+
+func (l eventList) header() []string {
+	return append([]string{"id", "calendar"}, csvHeader...)
+}
+
+func (l eventList) rows() [][]string {
+	rows := make([][]string, len(l))
+	for i, e := range l {
+		in := e.input()
+		rows[i] = []string{strconv.FormatInt(e.ID, 10), e.Calendar, in.Title, in.Date, in.Location, in.Description,
+			formatCoordinate(in.Latitude), formatCoordinate(in.Longitude), formatDays(e.Days)}
+	}
+	return rows
+}
+
+func (l eventList) vevents() []vevent {
+	events := make([]vevent, len(l))
+	for i, e := range l {
+		events[i] = vevent{
+			uid:         fmt.Sprintf("%d.%s@go-web-workshop", e.ID, e.Calendar),
+			stamp:       e.Updated,
+			date:        e.Date,
+			days:        e.Days,
+			summary:     e.Title,
+			location:    e.Location,
+			description: e.Description,
+			geo:         e.coordinates(),
+		}
+	}
+	return events
+}
+
+func (e Event) xmlDocument() interface{} {
+	return struct {
+		XMLName xml.Name `xml:"event"`
+		Event
+	}{Event: e}
+}
+
+// A single event is encoded in CSV and iCalendar as a list of one event.
+func (e Event) header() []string  { return eventList{e}.header() }
+func (e Event) rows() [][]string  { return eventList{e}.rows() }
+func (e Event) vevents() []vevent { return eventList{e}.vevents() }
+
 func init() {
 	r := mux.NewRouter()
-	r.HandleFunc("/api/events", listEvents).Methods("GET")
-	r.HandleFunc("/api/events", addEvent).Methods("POST")
+	r.Use(traceRequests, logRequests, instrument, compress, limitRate, limitBody)
+	r.HandleFunc("/", showEvents).Methods("GET")
+	r.HandleFunc("/api/calendars", listCalendars).Methods("GET")
+	r.HandleFunc("/api/calendars", addCalendar).Methods("POST")
+	r.Handle(calendarPath, withCalendar{h: getCalendar}).Methods("GET")
+	r.Handle(calendarPath, withCalendar{updateCalendar, true}).Methods("PUT")
+	// The events under /api/events are the ones in the default calendar.
+	for _, prefix := range []string{"/api", calendarPath} {
+		r.Handle(prefix+"/events", withCalendar{h: listEvents}).Methods("GET")
+		r.Handle(prefix+"/events", idempotent(withCalendar{h: addEvent})).Methods("POST")
+		r.Handle(prefix+"/events:import", idempotent(withCalendar{h: importEvents})).Methods("POST")
+		r.Handle(prefix+"/events:export", withCalendar{h: exportEvents}).Methods("GET")
+		r.Handle(prefix+"/events/calendar", withCalendar{h: showCalendarView}).Methods("GET")
+		r.Handle(prefix+"/events/archive", withCalendar{h: listArchive}).Methods("GET")
+		r.Handle(prefix+"/events/{id:[0-9]+}", withCalendar{h: getEvent}).Methods("GET")
+		r.Handle(prefix+"/events/{id:[0-9]+}", withCalendar{h: updateEvent}).Methods("PUT")
+		r.Handle(prefix+"/events/{id:[0-9]+}", withCalendar{h: deleteEvent}).Methods("DELETE")
+		r.Handle(prefix+"/events/{id:[0-9]+}/history", withCalendar{h: getHistory}).Methods("GET")
+		r.Handle(prefix+"/events/{id:[0-9]+}/restore", withCalendar{h: restoreEvent}).Methods("POST")
+	}
+	r.HandleFunc("/api/subscribe", subscribe).Methods("POST")
+	r.HandleFunc("/api/subscribe/confirm", confirmSubscription).Methods("GET")
+	r.HandleFunc("/api/unsubscribe", unsubscribe).Methods("GET")
+	r.HandleFunc("/api/cron/reminders", sendReminders).Methods("GET")
+	r.HandleFunc("/api/cron/digest", sendDigest).Methods("GET")
+	r.HandleFunc("/api/cron/idempotency", cleanupIdempotency).Methods("GET")
+	r.HandleFunc("/api/webhooks", listWebhooks).Methods("GET")
+	r.HandleFunc("/api/webhooks", addWebhook).Methods("POST")
+	r.HandleFunc("/api/webhooks/{id:[0-9]+}", deleteWebhook).Methods("DELETE")
+	r.HandleFunc("/api/webhooks/{id:[0-9]+}/deliveries", listDeliveries).Methods("GET")
+	r.HandleFunc("/api/tasks/deliver", deliverWebhook).Methods("POST")
+	r.HandleFunc("/api/admin/config", showConfig).Methods("GET")
+	r.HandleFunc("/api/admin/submissions", listSubmissions).Methods("GET")
+	r.HandleFunc(submissionPath, getSubmission).Methods("GET")
+	r.HandleFunc(submissionPath, updateSubmission).Methods("PUT")
+	r.HandleFunc(submissionPath+":approve", approveSubmission).Methods("POST")
+	r.HandleFunc(submissionPath+":reject", rejectSubmission).Methods("POST")
+	r.HandleFunc("/api/openapi.json", serveOpenAPI).Methods("GET")
+	r.HandleFunc("/api/graphql", serveGraphQL).Methods("POST")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	r.HandleFunc("/healthz", healthz).Methods("GET")
+	r.HandleFunc("/readyz", readyz).Methods("GET")
 	http.Handle("/", r)
+
+	// Fail early if any route is missing from the API documentation.
+	addCalendarDocs(apiDocs)
+	if err := checkDocs(r, apiDocs); err != nil {
+		panic(err)
+	}
+	spec, err := buildOpenAPI(apiDocs)
+	if err != nil {
+		panic(err)
+	}
+	openAPISpec = spec
+}
+
+func listEvents(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	if r.FormValue("near") != "" {
+		listNearbyEvents(w, r, calendar)
+		return
+	}
+	// Past events are listed too from the first one, a page at a time.
+	if r.FormValue("include_past") == "true" {
+		serveEventPage(w, r, platform.NewQuery(eventKind).Ancestor(calendar).Order("Date"))
+		return
+	}
+
+	events, err := upcomingEvents(ctx, calendar)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveEncoded(ctx, w, r, "", time.Time{}, eventList(events))
 }
 
-func listEvents(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// upcomingEvents returns the next few events in the calendar, with weather.
+func upcomingEvents(ctx context.Context, calendar *platform.Key) ([]Event, error) {
 	events := []Event{}
-	q := datastore.NewQuery(eventKind).
+	q := platform.NewQuery(eventKind).
+		Ancestor(calendar).
 		Filter("Date >", time.Now()).
 		Order("Date").
 		Limit(5)
 
-	if _, err := q.GetAll(ctx, &events); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	start := time.Now()
+	keys, err := q.GetAll(ctx, &events)
+	storeDuration.WithLabelValues("upcoming events").Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		events[i].setKey(key)
 	}
 
+	addWeather(ctx, events)
+	return events, nil
+}
+
+// addWeather fetches the weather for the location of each event.
+// If that fails the error is logged and the event is left without weather.
+func addWeather(ctx context.Context, events []Event) {
+	if cfg.WeatherDisabled {
+		return
+	}
 	for i, e := range events {
 		w, err := weather(ctx, e.Location)
 		if err != nil {
-			log.Errorf(ctx, "fetching weather for %q: %v", e.Location, err)
+			platform.Errorf(ctx, "fetching weather for %q: %v", e.Location, err)
 			continue
 		}
 		events[i].Weather = w
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(events); err != nil {
-		log.Errorf(ctx, "encoding events: %v", err)
+func addEvent(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	if readOnly(w) {
+		return
+	}
+
+	// Browsers without JavaScript send the form in the events page.
+	if isForm(r) {
+		addEventFromForm(w, r, calendar)
+		return
+	}
+	if !requireJSON(w, r) || !checkCaptcha(w, r) {
+		return
+	}
+	if moderated(ctx) {
+		submitEvent(w, r, calendar)
+		return
+	}
+
+	e, err := decodeEvent(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	allowDuplicate := r.FormValue("allow_duplicate") == "true"
+	if err := createEvent(ctx, calendar, e, author(r), allowDuplicate); err == errDuplicateEvent {
+		w.Header().Set("Location", eventPath(e))
+		http.Error(w, err.Error()+", set allow_duplicate to create it anyway", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Location", eventPath(e))
+	w.Header().Set("ETag", eventETag(e))
+	writeJSON(ctx, w, http.StatusCreated, e)
 }
 
-func addEvent(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// createEvent stores a new event in the calendar, recording who created it.
+// Unless allowDuplicate is true, if the calendar has an event with the same
+// title, date and location e gets its id and errDuplicateEvent is returned.
+func createEvent(ctx context.Context, calendar *platform.Key, e *Event, author string, allowDuplicate bool) error {
+	err := platform.RunInTransaction(ctx, func(ctx context.Context) error {
+		return insertEvent(ctx, calendar, e, author, allowDuplicate)
+	}, false)
+	if err != nil {
+		return err
+	}
+	notifyWebhooks(ctx, eventCreated, e)
+	return nil
+}
 
-	if os.Getenv("BLOCK_WRITES") != "" {
-		http.Error(w, "this is a read only instance, sorry", http.StatusForbidden)
+// insertEvent does the work of createEvent in a transaction on the calendar,
+// without notifying the webhooks.
+func insertEvent(ctx context.Context, calendar *platform.Key, e *Event, author string, allowDuplicate bool) error {
+	e.Updated = time.Now()
+	if !allowDuplicate {
+		if err := findDuplicate(ctx, calendar, e); err != nil {
+			return err
+		}
+	}
+
+	key, err := platform.Put(ctx, platform.NewIncompleteKey(eventKind, calendar), e)
+	if err != nil {
+		return err
+	}
+	e.setKey(key)
+	return addRevision(ctx, key, revisionCreated, author, nil, e)
+}
+
+// insertEvents stores new events in the calendar with their first revisions,
+// in two calls to PutMulti, without checking for duplicates or notifying the
+// webhooks. It's used by imports, in a transaction on the calendar.
+func insertEvents(ctx context.Context, calendar *platform.Key, events []*Event, author string) error {
+	if len(events) == 0 {
+		return nil
+	}
+	now := time.Now()
+	keys := make([]*platform.Key, len(events))
+	for i, e := range events {
+		e.Updated = now
+		keys[i] = platform.NewIncompleteKey(eventKind, calendar)
+	}
+	keys, err := platform.PutMulti(ctx, keys, events)
+	if err != nil {
+		return err
+	}
+
+	revKeys := make([]*platform.Key, len(events))
+	revs := make([]*Revision, len(events))
+	for i, e := range events {
+		e.setKey(keys[i])
+		revKeys[i] = platform.NewIncompleteKey(revisionKind, keys[i])
+		revs[i] = newRevision(revisionCreated, author, nil, e)
+	}
+	if _, err := platform.PutMulti(ctx, revKeys, revs); err != nil {
+		return fmt.Errorf("could not store revisions: %v", err)
+	}
+	return nil
+}
+
+// findDuplicate returns errDuplicateEvent, setting the id of e to the one of
+// the duplicate, if the calendar has an event with the same title, date and
+// location as e.
+func findDuplicate(ctx context.Context, calendar *platform.Key, e *Event) error {
+	keys, err := platform.NewQuery(eventKind).
+		Ancestor(calendar).
+		Filter("Title =", e.Title).
+		Filter("Date =", e.Date).
+		Filter("Location =", e.Location).
+		KeysOnly().
+		Limit(1).
+		GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		e.setKey(keys[0])
+		return errDuplicateEvent
+	}
+	return nil
+}
+
+func getEvent(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	key, e, err := eventByID(ctx, calendar, mux.Vars(r)["id"])
+	if err == platform.ErrNoSuchEntity {
+		http.Error(w, "event not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.setKey(key)
+
+	events := []Event{*e}
+	addWeather(ctx, events)
+	serveEncoded(ctx, w, r, eventETag(e), e.Updated, events[0])
+}
+
+func updateEvent(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	if readOnly(w) || !requireUser(w, r) || !requireIfMatch(w, r) || !requireJSON(w, r) {
+		return
+	}
+
+	key, err := eventKey(ctx, calendar, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "event not found", http.StatusNotFound)
 		return
 	}
 
@@ -94,46 +411,239 @@ func addEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key := datastore.NewIncompleteKey(ctx, eventKind, nil)
-	if _, err := datastore.Put(ctx, key, e); err != nil {
+	err = platform.RunInTransaction(ctx, func(ctx context.Context) error {
+		var old Event
+		if err := platform.Get(ctx, key, &old); err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, &old); err != nil {
+			return err
+		}
+		e.Updated = time.Now()
+		if _, err := platform.Put(ctx, key, e); err != nil {
+			return err
+		}
+		return addRevision(ctx, key, revisionUpdated, author(r), &old, e)
+	}, false)
+	if err == platform.ErrNoSuchEntity {
+		http.Error(w, "event not found", http.StatusNotFound)
+		return
+	} else if err == errPreconditionFailed {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	e.setKey(key)
+	notifyWebhooks(ctx, eventUpdated, e)
 
-	w.WriteHeader(http.StatusCreated)
+	w.Header().Set("ETag", eventETag(e))
+	writeJSON(ctx, w, http.StatusOK, e)
 }
 
-func decodeEvent(r io.Reader) (*Event, error) {
-	// Using an anonymous type instead of Event because the date format
-	// we need to parse is not standard.
-	// You can find more on this in this talk: https://talks.golang.org/2015/json.slide
-	var data struct {
-		Title       string
-		Date        string
-		Location    string
-		Description string
+// deleteEvent moves the event to the deletedEventKind, so it can be
+// restored with restoreEvent.
+func deleteEvent(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	if readOnly(w) || !requireUser(w, r) || !requireIfMatch(w, r) {
+		return
+	}
+
+	key, err := eventKey(ctx, calendar, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "event not found", http.StatusNotFound)
+		return
+	}
+	deleted := platform.NewKey(deletedEventKind, "", key.IntID(), calendar)
+
+	var e Event
+	err = platform.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := platform.Get(ctx, key, &e); err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, &e); err != nil {
+			return err
+		}
+		if _, err := platform.Put(ctx, deleted, &e); err != nil {
+			return err
+		}
+		if err := platform.Delete(ctx, key); err != nil {
+			return err
+		}
+		return addRevision(ctx, key, revisionDeleted, author(r), &e, nil)
+	}, true)
+	if err == platform.ErrNoSuchEntity {
+		http.Error(w, "event not found", http.StatusNotFound)
+		return
+	} else if err == errPreconditionFailed {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.setKey(key)
+	notifyWebhooks(ctx, eventDeleted, &e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// eventKey returns the key of the event with the given id in the calendar.
+func eventKey(ctx context.Context, calendar *platform.Key, id string) (*platform.Key, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid event id %q", id)
+	}
+	return platform.NewKey(eventKind, "", n, calendar), nil
+}
+
+// eventByID fetches the event with the given id in the calendar, returning
+// platform.ErrNoSuchEntity if the id is not valid or there's no such event.
+func eventByID(ctx context.Context, calendar *platform.Key, id string) (*platform.Key, *Event, error) {
+	key, err := eventKey(ctx, calendar, id)
+	if err != nil {
+		return nil, nil, platform.ErrNoSuchEntity
+	}
+	var e Event
+	if err := platform.Get(ctx, key, &e); err != nil {
+		return nil, nil, err
+	}
+	return key, &e, nil
+}
+
+// setKey sets the fields of the event identifying it from its key.
+func (e *Event) setKey(key *platform.Key) {
+	e.ID = key.IntID()
+	e.Calendar = key.Parent().StringID()
+}
+
+// eventPath returns the path of the event in the API.
+func eventPath(e *Event) string {
+	if e.Calendar == defaultCalendar {
+		return fmt.Sprintf("/api/events/%d", e.ID)
+	}
+	return fmt.Sprintf("/api/calendars/%s/events/%d", e.Calendar, e.ID)
+}
+
+// readOnly checks whether writes are blocked in this instance, writing an
+// error to the response if they are.
+func readOnly(w http.ResponseWriter) bool {
+	if writesBlocked() {
+		http.Error(w, "this is a read only instance, sorry", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// writesBlocked returns whether this instance is read only.
+func writesBlocked() bool {
+	return cfg.BlockWrites
+}
+
+// writeJSON encodes v as the JSON body of the response with the given status.
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		platform.Errorf(ctx, "encoding response: %v", err)
 	}
+}
 
+func decodeEvent(r io.Reader) (*Event, error) {
+	var data eventInput
 	err := json.NewDecoder(r).Decode(&data)
 	if err != nil {
 		return nil, fmt.Errorf("could not decode JSON: %v", err)
 	}
+	return data.event()
+}
+
+// eventInput contains the fields of an event as sent by clients.
+// We use it instead of Event because the date format we need to parse is
+// not standard.
+// You can find more on this in this talk: https://talks.golang.org/2015/json.slide
+type eventInput struct {
+	Title       string `json:"title"`
+	Date        string `json:"date"`
+	Location    string `json:"location"`
+	Description string `json:"description"`
+	// Days is optional, events last a single day by default or with 0 or 1
+	// days. It's an int32 as the Int type of GraphQL.
+	Days int32 `json:"days,omitempty"`
+	// Latitude and Longitude are optional, but go together.
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+}
 
+// input returns the representation of the event used by clients.
+func (e Event) input() eventInput {
+	in := eventInput{
+		Title:       e.Title,
+		Date:        e.Date.Format(dateFormat),
+		Location:    e.Location,
+		Description: e.Description,
+		Days:        int32(e.Days),
+	}
+	if p := e.coordinates(); p != nil {
+		in.Latitude, in.Longitude = &p.lat, &p.lon
+	}
+	return in
+}
+
+// event validates the input and returns the corresponding Event.
+func (data eventInput) event() (*Event, error) {
 	if data.Title == "" {
 		return nil, fmt.Errorf("title is required")
 	}
 	if data.Location == "" {
 		return nil, fmt.Errorf("location is required")
 	}
-	t, err := time.Parse("2006-01-02", data.Date)
+	t, err := time.Parse(dateFormat, data.Date)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse date: %v", err)
 	}
+	if data.Days < 0 || data.Days > maxEventDays {
+		return nil, fmt.Errorf("days must be between 0 and %d, 0 and 1 are a single day", maxEventDays)
+	}
+	if data.Days == 1 {
+		data.Days = 0
+	}
 
-	return &Event{
+	e := &Event{
 		Title:       data.Title,
 		Date:        t,
+		Days:        int(data.Days),
 		Description: data.Description,
 		Location:    data.Location,
-	}, nil
+	}
+	if (data.Latitude == nil) != (data.Longitude == nil) {
+		return nil, fmt.Errorf("latitude and longitude go together")
+	}
+	if data.Latitude != nil {
+		p := point{*data.Latitude, *data.Longitude}
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		e.setCoordinates(&p)
+	}
+	return e, nil
+}
+
+// formatDays returns the number of days of an event as text, empty for
+// events lasting a single day.
+func formatDays(days int) string {
+	if days <= 1 {
+		return ""
+	}
+	return strconv.Itoa(days)
+}
+
+// lastDay returns the date of the last day of the event.
+func (e *Event) lastDay() time.Time {
+	if e.Days <= 1 {
+		return e.Date
+	}
+	return e.Date.AddDate(0, 0, e.Days-1)
 }
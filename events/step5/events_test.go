@@ -0,0 +1,157 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/campoy/go-web-workshop/platform/platformtest"
+)
+
+// setup installs fakes for the platform and a configuration without the
+// weather API, restored at the end of the test.
+func setup(t *testing.T) *platformtest.Fakes {
+	f, restore := platformtest.Install()
+	prev := cfg
+	cfg = config{WeatherDisabled: true}
+	t.Cleanup(func() {
+		cfg = prev
+		restore()
+	})
+	return f
+}
+
+// serve sends a request with the given body, JSON unless a Content-Type is
+// among the headers, to the router and returns the response.
+func serve(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	var b io.Reader
+	if body != "" {
+		b = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, b)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, r)
+	return w
+}
+
+// expect fails the test unless the response has the given status.
+func expect(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("got status %d, want %d: %s", w.Code, status, strings.TrimSpace(w.Body.String()))
+	}
+}
+
+// titles returns the titles of the events in a JSON list.
+func titles(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var events []Event
+	if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+		t.Fatalf("could not decode events: %v: %s", err, w.Body.String())
+	}
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Title)
+	}
+	return strings.Join(got, ",")
+}
+
+// eventJSON returns an event as sent by clients, days from today.
+func eventJSON(title string, days int) string {
+	date := time.Now().AddDate(0, 0, days).Format(dateFormat)
+	return fmt.Sprintf(`{"title": %q, "date": %q, "location": "Paris"}`, title, date)
+}
+
+func TestListEvents(t *testing.T) {
+	f := setup(t)
+	cfg = config{WeatherAPIKey: "key"}
+	f.Transport.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"weather": [{"description": "sunny in %s", "icon": "01d"}]}`, r.FormValue("q"))
+	})
+
+	for i, title := range []string{"Past", "Sixth", "Third", "First", "Fifth", "Second", "Fourth"} {
+		days := []int{-1, 6, 3, 1, 5, 2, 4}[i]
+		expect(t, serve("POST", "/api/events", eventJSON(title, days)), 201)
+	}
+
+	// The next five events are listed, in order, with their weather.
+	w := serve("GET", "/api/events", "")
+	expect(t, w, 200)
+	if got, want := titles(t, w), "First,Second,Third,Fourth,Fifth"; got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+	if !strings.Contains(w.Body.String(), `"description":"sunny in Paris"`) {
+		t.Errorf("the events have no weather: %s", w.Body.String())
+	}
+
+	// With include_past they're all listed, the past ones first.
+	w = serve("GET", "/api/events?include_past=true", "")
+	expect(t, w, 200)
+	if got, want := titles(t, w), "Past,First,Second,Third,Fourth,Fifth,Sixth"; got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+}
+
+func TestAddEvent(t *testing.T) {
+	setup(t)
+
+	w := serve("POST", "/api/events", eventJSON("Meetup", 1))
+	expect(t, w, 201)
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/api/events/") {
+		t.Fatalf("got Location %q, want the path of the event", location)
+	}
+	w = serve("GET", location, "")
+	expect(t, w, 200)
+	if !strings.Contains(w.Body.String(), `"title":"Meetup"`) {
+		t.Errorf("got %s, want the event", w.Body.String())
+	}
+
+	// The same event is only created again when asked to.
+	w = serve("POST", "/api/events", eventJSON("Meetup", 1))
+	expect(t, w, 409)
+	if got := w.Header().Get("Location"); got != location {
+		t.Errorf("got Location %q, want the existing event %q", got, location)
+	}
+	expect(t, serve("POST", "/api/events?allow_duplicate=true", eventJSON("Meetup", 1)), 201)
+
+	for _, body := range []string{
+		`{"title": "Meetup"`,
+		`{"date": "2099-01-01", "location": "Paris"}`,
+		`{"title": "Meetup", "date": "tomorrow", "location": "Paris"}`,
+	} {
+		expect(t, serve("POST", "/api/events", body), 400)
+	}
+
+	cfg.BlockWrites = true
+	expect(t, serve("POST", "/api/events", eventJSON("Party", 2)), 403)
+
+	w = serve("GET", "/api/events", "")
+	if got, want := titles(t, w), "Meetup,Meetup"; got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+}
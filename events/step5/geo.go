@@ -0,0 +1,356 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+const (
+	// geohashPrecision is the length of the geohashes of events, whose cells
+	// are a few meters wide.
+	geohashPrecision = 9
+	geohashAlphabet  = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	// maxGeohashCells is the maximum number of cells covering the area of
+	// a search, each of them queried separately.
+	maxGeohashCells = 9
+
+	// earthRadius is the mean radius of the Earth, in kilometers.
+	earthRadius = 6371.0
+
+	// Radiuses of the searches of nearby events, in kilometers.
+	defaultRadius = 50.0
+	maxRadius     = 1000.0
+
+	// maxCellEvents is the maximum number of events loaded from each cell,
+	// the first ones by date.
+	maxCellEvents = 500
+)
+
+// point is a position on Earth, in degrees.
+type point struct {
+	lat, lon float64
+}
+
+func (p point) validate() error {
+	if math.IsNaN(p.lat) || p.lat < -90 || p.lat > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90")
+	}
+	if math.IsNaN(p.lon) || p.lon < -180 || p.lon > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// coordinates returns the position of the event, nil if it has none.
+func (e *Event) coordinates() *point {
+	if e.Geohash == "" {
+		return nil
+	}
+	return &point{e.Latitude, e.Longitude}
+}
+
+// setCoordinates sets the position of the event, or removes it if p is nil.
+func (e *Event) setCoordinates(p *point) {
+	if p == nil {
+		e.Latitude, e.Longitude, e.Geohash, e.GeohashPrefixes = 0, 0, "", nil
+		return
+	}
+	e.Latitude, e.Longitude, e.Geohash = p.lat, p.lon, geohash(*p, geohashPrecision)
+	e.GeohashPrefixes = geohashPrefixes(e.Geohash)
+}
+
+// geohashPrefixes returns the prefixes of the geohash, which are the cells
+// containing it, from the largest one to the geohash itself.
+func geohashPrefixes(hash string) []string {
+	prefixes := make([]string, len(hash))
+	for i := range prefixes {
+		prefixes[i] = hash[:i+1]
+	}
+	return prefixes
+}
+
+// formatCoordinate returns the coordinate as text, empty if it's nil.
+func formatCoordinate(c *float64) string {
+	if c == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*c, 'f', -1, 64)
+}
+
+// parseCoordinate parses a coordinate formatted by formatCoordinate.
+func parseCoordinate(s string) (*float64, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return nil, nil
+	}
+	c, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid coordinate %q", s)
+	}
+	return &c, nil
+}
+
+// geohash returns the geohash of p with the given number of characters.
+// Points with a common prefix are in the same cell, of the size of the
+// prefix, so the events in a cell are found by their prefixes.
+func geohash(p point, precision int) string {
+	minLat, maxLat, minLon, maxLon := -90.0, 90.0, -180.0, 180.0
+	b := make([]byte, precision)
+	for i := range b {
+		var c int
+		for bit := 0; bit < 5; bit++ {
+			// Bits alternate between longitude and latitude, starting with
+			// longitude.
+			c <<= 1
+			if (i*5+bit)%2 == 0 {
+				if mid := (minLon + maxLon) / 2; p.lon >= mid {
+					c, minLon = c|1, mid
+				} else {
+					maxLon = mid
+				}
+			} else {
+				if mid := (minLat + maxLat) / 2; p.lat >= mid {
+					c, minLat = c|1, mid
+				} else {
+					maxLat = mid
+				}
+			}
+		}
+		b[i] = geohashAlphabet[c]
+	}
+	return string(b)
+}
+
+// geohashCellSize returns the height and width in degrees of the cells of
+// geohashes with the given number of characters.
+func geohashCellSize(precision int) (lat, lon float64) {
+	bits := uint(5 * precision)
+	return 180 / float64(uint64(1)<<(bits/2)), 360 / float64(uint64(1)<<((bits+1)/2))
+}
+
+// box is an area between two parallels and two meridians.
+type box struct {
+	min, max point
+}
+
+// boundingBoxes returns the boxes containing the circle with the given
+// center and radius in kilometers, two of them if it crosses the 180th
+// meridian.
+func boundingBoxes(center point, radius float64) []box {
+	dLat := radius / earthRadius * 180 / math.Pi
+	minLat, maxLat := math.Max(center.lat-dLat, -90), math.Min(center.lat+dLat, 90)
+	cos := math.Cos(center.lat * math.Pi / 180)
+	if minLat == -90 || maxLat == 90 || dLat >= 180*cos {
+		// The circle contains a pole or goes around the Earth.
+		return []box{{point{minLat, -180}, point{maxLat, 180}}}
+	}
+
+	dLon := dLat / cos
+	minLon, maxLon := center.lon-dLon, center.lon+dLon
+	switch {
+	case minLon < -180:
+		return []box{{point{minLat, minLon + 360}, point{maxLat, 180}}, {point{minLat, -180}, point{maxLat, maxLon}}}
+	case maxLon > 180:
+		return []box{{point{minLat, minLon}, point{maxLat, 180}}, {point{minLat, -180}, point{maxLat, maxLon - 360}}}
+	}
+	return []box{{point{minLat, minLon}, point{maxLat, maxLon}}}
+}
+
+// geohashCells returns the geohashes of the cells covering the boxes, with
+// as many characters as possible without going over maxGeohashCells cells.
+func geohashCells(boxes []box) []string {
+	precision := geohashPrecision
+	for ; precision > 1; precision-- {
+		// A box overlaps at most two cells more than its size in cells.
+		h, w := geohashCellSize(precision)
+		n := 0.0
+		for _, b := range boxes {
+			n += (math.Floor((b.max.lat-b.min.lat)/h) + 2) * (math.Floor((b.max.lon-b.min.lon)/w) + 2)
+		}
+		if n <= maxGeohashCells {
+			break
+		}
+	}
+
+	h, w := geohashCellSize(precision)
+	seen := make(map[string]bool)
+	var cells []string
+	for _, b := range boxes {
+		rows, cols := (b.max.lat-b.min.lat)/h, (b.max.lon-b.min.lon)/w
+		if !(rows >= 0 && rows <= 180/h && cols >= 0 && cols <= 360/w) {
+			// Only boxes on Earth are covered, which also excludes NaN.
+			continue
+		}
+		// Moving by the size of a cell reaches every cell in the box, the
+		// last step being on its edge.
+		for i := 0; i <= int(math.Ceil(rows)); i++ {
+			lat := math.Min(b.min.lat+float64(i)*h, b.max.lat)
+			for j := 0; j <= int(math.Ceil(cols)); j++ {
+				lon := math.Min(b.min.lon+float64(j)*w, b.max.lon)
+				if cell := geohash(point{lat, lon}, precision); !seen[cell] {
+					seen[cell] = true
+					cells = append(cells, cell)
+				}
+			}
+		}
+	}
+	sort.Strings(cells)
+	return cells
+}
+
+// distance returns the great-circle distance between a and b in kilometers,
+// using the haversine formula.
+func distance(a, b point) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := rad(b.lat-a.lat), rad(b.lon-a.lon)
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(rad(a.lat))*math.Cos(rad(b.lat))*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// parseNear parses a point formatted as lat,lon.
+func parseNear(s string) (point, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return point{}, fmt.Errorf("near must be latitude,longitude")
+	}
+	var p point
+	var err error
+	if p.lat, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err != nil {
+		return point{}, fmt.Errorf("invalid latitude %q", parts[0])
+	}
+	if p.lon, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil {
+		return point{}, fmt.Errorf("invalid longitude %q", parts[1])
+	}
+	return p, p.validate()
+}
+
+// radiusUnits are the units accepted in radiuses, in kilometers.
+var radiusUnits = map[string]float64{"km": 1, "m": 0.001, "mi": 1.609344}
+
+// parseRadius parses a distance such as 50km, 800m or 10mi, returning it in
+// kilometers. Distances without unit are in kilometers.
+func parseRadius(s string) (float64, error) {
+	if s == "" {
+		return defaultRadius, nil
+	}
+	num, unit := strings.TrimRight(s, "abcdefghijklmnopqrstuvwxyz"), s
+	unit = strings.TrimSpace(unit[len(num):])
+	if unit == "" {
+		unit = "km"
+	}
+	factor, ok := radiusUnits[unit]
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	// The comparison is false for NaN.
+	if !ok || err != nil || !(n > 0) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid radius %q, use a distance such as 50km, 800m or 10mi", s)
+	}
+	if n*factor > maxRadius {
+		return 0, fmt.Errorf("radius must be at most %vkm", maxRadius)
+	}
+	return n * factor, nil
+}
+
+// listNearbyEvents lists the upcoming events, or all of them with the
+// include_past parameter, within the radius parameter of the point in the
+// near parameter, the closest first. The limit parameter is the number of
+// events listed, as in serveEventPage.
+func listNearbyEvents(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	limit, err := pageLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	center, err := parseNear(r.FormValue("near"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	radius, err := parseRadius(r.FormValue("radius"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := nearbyEvents(ctx, calendar, center, radius, r.FormValue("include_past") == "true", limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	addWeather(ctx, events)
+	serveEncoded(ctx, w, r, "", time.Time{}, eventList(events))
+}
+
+// nearbyEvents returns at most limit of the upcoming events in the calendar,
+// or of all of them if includePast is true, within radius kilometers of
+// center, ordered by distance. It queries the events in the geohash cells
+// covering the circle, by their prefix, and filters out the ones outside it.
+func nearbyEvents(ctx context.Context, calendar *platform.Key, center point, radius float64, includePast bool, limit int) ([]Event, error) {
+	events := []Event{}
+	start := time.Now()
+	for _, cell := range geohashCells(boundingBoxes(center, radius)) {
+		// Past events are filtered out by the query, so they don't count
+		// in the events loaded from the cell. With them, the most recent
+		// events are loaded.
+		q := platform.NewQuery(eventKind).
+			Ancestor(calendar).
+			Filter("GeohashPrefixes =", cell)
+		if includePast {
+			q = q.Order("-Date")
+		} else {
+			q = q.Filter("Date >", time.Now()).Order("Date")
+		}
+		var found []Event
+		keys, err := q.Limit(maxCellEvents).GetAll(ctx, &found)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == maxCellEvents {
+			platform.Errorf(ctx, "more than %d events in geohash cell %s, the last ones by date are missing", maxCellEvents, cell)
+		}
+		for i, e := range found {
+			d := distance(center, point{e.Latitude, e.Longitude})
+			if d > radius {
+				continue
+			}
+			e.setKey(keys[i])
+			e.Distance = &d
+			events = append(events, e)
+		}
+	}
+	storeDuration.WithLabelValues("nearby events").Observe(time.Since(start).Seconds())
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if *events[i].Distance != *events[j].Distance {
+			return *events[i].Distance < *events[j].Distance
+		}
+		return events[i].Date.Before(events[j].Date)
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+func TestGeohash(t *testing.T) {
+	if got, want := geohash(point{57.64911, 10.40744}, 11), "u4pruydqqvj"; got != want {
+		t.Errorf("geohash = %q, want %q", got, want)
+	}
+}
+
+func TestParseRadius(t *testing.T) {
+	for s, want := range map[string]float64{"": defaultRadius, "50": 50, "50km": 50, "800m": 0.8, "10mi": 16.09344} {
+		got, err := parseRadius(s)
+		if err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("parseRadius(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"NaN", "nan", "Inf", "+Inf", "-1", "0", "5000km", "10ft", "km"} {
+		if got, err := parseRadius(s); err == nil {
+			t.Errorf("parseRadius(%q) = %v, want an error", s, got)
+		}
+	}
+}
+
+func TestGeohashCells(t *testing.T) {
+	for _, tc := range []struct {
+		center point
+		radius float64
+	}{
+		{point{48.85, 2.35}, 10},
+		{point{0, 179.9}, 50},
+		{point{89.9, 0}, 50},
+		{point{-33.9, 151.2}, maxRadius},
+	} {
+		cells := geohashCells(boundingBoxes(tc.center, tc.radius))
+		if len(cells) == 0 || len(cells) > 32 {
+			t.Errorf("%v km around %v: got %d cells", tc.radius, tc.center, len(cells))
+		}
+		if c := geohash(tc.center, geohashPrecision); !coveredBy(c, cells) {
+			t.Errorf("%v km around %v: center %s not in cells %v", tc.radius, tc.center, c, cells)
+		}
+	}
+
+	// Boxes that are not on Earth are ignored rather than looping forever.
+	if cells := geohashCells(boundingBoxes(point{0, 0}, math.NaN())); len(cells) != 0 {
+		t.Errorf("got cells %v for a NaN radius", cells)
+	}
+}
+
+func coveredBy(hash string, cells []string) bool {
+	for _, c := range cells {
+		if len(hash) >= len(c) && hash[:len(c)] == c {
+			return true
+		}
+	}
+	return false
+}
+
+func TestListNearbyEvents(t *testing.T) {
+	setup(t)
+	for _, body := range []string{
+		`{"title": "Paris", "date": "2099-01-01", "location": "Paris", "latitude": 48.8566, "longitude": 2.3522}`,
+		`{"title": "Versailles", "date": "2099-01-01", "location": "Versailles", "latitude": 48.8049, "longitude": 2.1204}`,
+		`{"title": "London", "date": "2099-01-01", "location": "London", "latitude": 51.5074, "longitude": -0.1278}`,
+		`{"title": "Past", "date": "2000-01-01", "location": "Paris", "latitude": 48.8566, "longitude": 2.3522}`,
+	} {
+		expect(t, serve("POST", "/api/events", body), http.StatusCreated)
+	}
+
+	w := serve("GET", "/api/events?near=48.85,2.35&radius=30km", "")
+	expect(t, w, http.StatusOK)
+	var events []Event
+	if err := json.NewDecoder(w.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, e := range events {
+		titles = append(titles, e.Title)
+	}
+	if got, want := strings.Join(titles, ","), "Paris,Versailles"; got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+	if d := events[1].Distance; d == nil || math.Abs(*d-17.5) > 0.1 {
+		t.Errorf("got distance %v to Versailles, want about 17.5km", d)
+	}
+
+	for _, q := range []string{"near=0,0&radius=NaN", "near=0,0&radius=Inf", "near=NaN,0", "near=91,0", "near=foo"} {
+		expect(t, serve("GET", "/api/events?"+q, ""), http.StatusBadRequest)
+	}
+}
+
+func TestListNearbyEventsLimits(t *testing.T) {
+	setup(t)
+
+	// Past events in a busy cell don't hide the upcoming ones.
+	calendar := calendarKey(defaultCalendar)
+	keys := make([]*platform.Key, maxCellEvents+1)
+	past := make([]*Event, len(keys))
+	for i := range keys {
+		keys[i] = platform.NewIncompleteKey(eventKind, calendar)
+		past[i] = &Event{Title: fmt.Sprintf("Past %d", i), Date: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), Location: "Paris"}
+		past[i].setCoordinates(&point{48.8566, 2.3522})
+	}
+	if _, err := platform.PutMulti(context.Background(), keys, past); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		body := fmt.Sprintf(`{"title": "Meetup %d", "date": "2099-01-0%d", "location": "Paris", "latitude": 48.8566, "longitude": 2.3522}`, i, i+1)
+		expect(t, serve("POST", "/api/events", body), http.StatusCreated)
+	}
+
+	w := serve("GET", "/api/events?near=48.85,2.35&radius=1km", "")
+	expect(t, w, http.StatusOK)
+	if got, want := titles(t, w), "Meetup 0,Meetup 1,Meetup 2"; got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+	w = serve("GET", "/api/events?near=48.85,2.35&radius=1km&limit=2", "")
+	expect(t, w, http.StatusOK)
+	if got, want := titles(t, w), "Meetup 0,Meetup 1"; got != want {
+		t.Errorf("with limit=2 got events %s, want %s", got, want)
+	}
+	w = serve("GET", "/api/events?near=48.85,2.35&radius=1km&include_past=true", "")
+	expect(t, w, http.StatusOK)
+	if got := strings.Count(titles(t, w), ","); got != defaultPageSize-1 {
+		t.Errorf("with past events got %d events, want %d", got+1, defaultPageSize)
+	}
+	for _, limit := range []string{"0", "101", "ten"} {
+		expect(t, serve("GET", "/api/events?near=48.85,2.35&limit="+limit, ""), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+const graphQLSchemaString = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+# Queries and mutations are on the events of the default calendar.
+type Query {
+	# Events ordered by date. By default only the upcoming ones.
+	events(filter: EventFilter, first: Int, after: String): EventConnection!
+	event(id: ID!): Event
+}
+
+type Mutation {
+	addEvent(input: EventInput!): Event!
+}
+
+# Dates are in the YYYY-MM-DD format.
+input EventFilter {
+	location: String
+	from: String
+	to: String
+}
+
+input EventInput {
+	title: String!
+	date: String!
+	location: String!
+	description: String! = ""
+	days: Int! = 1
+	# Latitude and longitude are set together, in degrees.
+	latitude: Float
+	longitude: Float
+}
+
+type EventConnection {
+	edges: [EventEdge!]!
+	pageInfo: PageInfo!
+}
+
+type EventEdge {
+	cursor: String!
+	node: Event!
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	endCursor: String
+}
+
+type Event {
+	id: ID!
+	title: String!
+	description: String!
+	date: String!
+	days: Int!
+	location: String!
+	latitude: Float
+	longitude: Float
+	updated: String
+	# The weather is only fetched when requested.
+	weather: Weather
+}
+
+type Weather {
+	description: String!
+	icon: String!
+}
+`
+
+// Limits to the number of events returned in a page.
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+var graphQLHandler = &relay.Handler{
+	Schema: graphql.MustParseSchema(graphQLSchemaString, &graphQLResolver{}),
+}
+
+// authorKey is the context key for the author of a GraphQL request.
+type authorKey struct{}
+
+func serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+	ctx := context.WithValue(platform.NewContext(r), authorKey{}, author(r))
+	// The CAPTCHA is only verified by the mutations, at most once.
+	var once sync.Once
+	var captchaErr error
+	ctx = context.WithValue(ctx, captchaKey{}, func() error {
+		once.Do(func() { captchaErr = verifyCaptcha(r) })
+		return captchaErr
+	})
+	graphQLHandler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// captchaKey is the context key of the function verifying the CAPTCHA of
+// the GraphQL request, see verifyCaptcha.
+type captchaKey struct{}
+
+// graphQLCaptcha verifies the response to the CAPTCHA sent with the GraphQL
+// request in ctx.
+func graphQLCaptcha(ctx context.Context) error {
+	verify, ok := ctx.Value(captchaKey{}).(func() error)
+	if !ok {
+		return errCaptchaFailed
+	}
+	return verify()
+}
+
+// graphQLResolver resolves the queries and mutations in the schema.
+type graphQLResolver struct{}
+
+type eventFilter struct {
+	Location *string
+	From     *string
+	To       *string
+}
+
+func (*graphQLResolver) Events(ctx context.Context, args struct {
+	Filter *eventFilter
+	First  *int32
+	After  *string
+}) (*eventConnectionResolver, error) {
+	first := defaultPageSize
+	if args.First != nil {
+		first = int(*args.First)
+	}
+	if first < 0 || first > maxPageSize {
+		return nil, fmt.Errorf("first must be between 0 and %d", maxPageSize)
+	}
+
+	from := time.Now()
+	q := platform.NewQuery(eventKind).Ancestor(calendarKey(defaultCalendar)).Order("Date")
+	if f := args.Filter; f != nil {
+		if f.From != nil {
+			t, err := time.Parse(dateFormat, *f.From)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse from: %v", err)
+			}
+			from = t
+		}
+		if f.To != nil {
+			t, err := time.Parse(dateFormat, *f.To)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse to: %v", err)
+			}
+			q = q.Filter("Date <=", t)
+		}
+		if f.Location != nil {
+			q = q.Filter("Location =", *f.Location)
+		}
+	}
+	q = q.Filter("Date >=", from)
+	if args.After != nil {
+		q = q.Start(*args.After)
+	}
+
+	// We fetch one more event than requested to know if there's a next page.
+	conn := &eventConnectionResolver{}
+	t := q.Limit(first + 1).Run(ctx)
+	for i := 0; ; i++ {
+		var e Event
+		key, err := t.Next(&e)
+		if err == platform.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i == first {
+			conn.hasNextPage = true
+			break
+		}
+		c, err := t.Cursor()
+		if err != nil {
+			return nil, err
+		}
+		e.setKey(key)
+		conn.edges = append(conn.edges, &eventEdgeResolver{c, &eventResolver{&e}})
+	}
+	return conn, nil
+}
+
+func (*graphQLResolver) Event(ctx context.Context, args struct{ ID graphql.ID }) (*eventResolver, error) {
+	key, e, err := eventByID(ctx, calendarKey(defaultCalendar), string(args.ID))
+	if err == platform.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	e.setKey(key)
+	return &eventResolver{e}, nil
+}
+
+func (*graphQLResolver) AddEvent(ctx context.Context, args struct{ Input eventInput }) (*eventResolver, error) {
+	if writesBlocked() {
+		return nil, errors.New("this is a read only instance, sorry")
+	}
+	calendar := calendarKey(defaultCalendar)
+	c, err := loadCalendar(ctx, calendar)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkChange(ctx); err != nil {
+		return nil, err
+	}
+	if err := graphQLCaptcha(ctx); err != nil {
+		return nil, err
+	}
+	if moderated(ctx) {
+		return nil, errors.New("events added by anonymous users are moderated, sign in or submit them with POST /api/events")
+	}
+	e, err := args.Input.event()
+	if err != nil {
+		return nil, err
+	}
+	who, _ := ctx.Value(authorKey{}).(string)
+	if err := createEvent(ctx, calendar, e, who, false); err != nil {
+		return nil, err
+	}
+	return &eventResolver{e}, nil
+}
+
+type eventConnectionResolver struct {
+	edges       []*eventEdgeResolver
+	hasNextPage bool
+}
+
+func (c *eventConnectionResolver) Edges() []*eventEdgeResolver { return c.edges }
+
+func (c *eventConnectionResolver) PageInfo() *pageInfoResolver {
+	p := &pageInfoResolver{hasNextPage: c.hasNextPage}
+	if n := len(c.edges); n > 0 {
+		p.endCursor = &c.edges[n-1].cursor
+	}
+	return p
+}
+
+type eventEdgeResolver struct {
+	cursor string
+	node   *eventResolver
+}
+
+func (e *eventEdgeResolver) Cursor() string       { return e.cursor }
+func (e *eventEdgeResolver) Node() *eventResolver { return e.node }
+
+type pageInfoResolver struct {
+	hasNextPage bool
+	endCursor   *string
+}
+
+func (p *pageInfoResolver) HasNextPage() bool  { return p.hasNextPage }
+func (p *pageInfoResolver) EndCursor() *string { return p.endCursor }
+
+type eventResolver struct{ e *Event }
+
+func (r *eventResolver) ID() graphql.ID      { return graphql.ID(strconv.FormatInt(r.e.ID, 10)) }
+func (r *eventResolver) Title() string       { return r.e.Title }
+func (r *eventResolver) Description() string { return r.e.Description }
+func (r *eventResolver) Date() string        { return r.e.Date.Format(dateFormat) }
+func (r *eventResolver) Location() string    { return r.e.Location }
+
+func (r *eventResolver) Days() int32 {
+	if r.e.Days <= 1 {
+		return 1
+	}
+	return int32(r.e.Days)
+}
+
+func (r *eventResolver) Latitude() *float64 {
+	if p := r.e.coordinates(); p != nil {
+		return &p.lat
+	}
+	return nil
+}
+
+func (r *eventResolver) Longitude() *float64 {
+	if p := r.e.coordinates(); p != nil {
+		return &p.lon
+	}
+	return nil
+}
+
+func (r *eventResolver) Updated() *string {
+	if r.e.Updated.IsZero() {
+		return nil
+	}
+	s := r.e.Updated.Format(time.RFC3339)
+	return &s
+}
+
+// Weather is only called when the weather is part of the query, so clients
+// that don't ask for it don't wait for the weather API.
+func (r *eventResolver) Weather(ctx context.Context) *weatherResolver {
+	if cfg.WeatherDisabled {
+		return nil
+	}
+	w, err := weather(ctx, r.e.Location)
+	if err != nil {
+		platform.Errorf(ctx, "fetching weather for %q: %v", r.e.Location, err)
+		return nil
+	}
+	return &weatherResolver{w}
+}
+
+type weatherResolver struct{ w *Weather }
+
+func (r *weatherResolver) Description() string { return r.w.Description }
+func (r *weatherResolver) Icon() string        { return r.w.Icon }
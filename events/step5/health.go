@@ -0,0 +1,198 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+// Statuses reported by the readiness check.
+const (
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+	statusDown     = "down"
+	// statusDisabled is reported for dependencies that are not used.
+	statusDisabled = "disabled"
+)
+
+const (
+	// checkTimeout is how long each dependency has to answer.
+	checkTimeout = 2 * time.Second
+	// weatherCheckTTL is how long the result of checking the weather API is
+	// reused, so readiness probes don't use up the API quota. Failures are
+	// only reused for weatherRetryTTL, so recoveries are seen sooner.
+	weatherCheckTTL = time.Minute
+	weatherRetryTTL = 10 * time.Second
+	// healthCacheKey is the cache key written and read to check the cache.
+	healthCacheKey = "health-check"
+	// healthLocation is the location whose weather is fetched to check the
+	// weather API.
+	healthLocation = "London"
+)
+
+// dependency is something the application needs. If a dependency that is
+// not critical fails the application is degraded rather than down.
+type dependency struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+	// disabled, if not nil, reports whether the dependency is not used.
+	disabled func() bool
+}
+
+var dependencies = []dependency{
+	{"datastore", true, checkDatastore, nil},
+	{"cache", true, checkCache, nil},
+	{"weather", false, checkWeather, func() bool { return cfg.WeatherDisabled }},
+}
+
+// Errors reported by the readiness check. The errors of the dependencies
+// may contain secrets, such as the weather API key in its URL, so they are
+// only logged.
+var (
+	errCheckTimeout = errors.New("timed out")
+	errCheckFailed  = errors.New("check failed, see the logs for details")
+)
+
+// checkResult is the result of checking a dependency.
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// readiness is the response of the readiness check.
+type readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// healthz reports that the process is alive.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(platform.NewContext(r), w, http.StatusOK, map[string]string{"status": statusOK})
+}
+
+// readyz checks the dependencies of the application concurrently, each with
+// a timeout. The application is down, and can't serve requests, if any of
+// the critical ones fails.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	results := make([]checkResult, len(dependencies))
+	var wg sync.WaitGroup
+	for i, d := range dependencies {
+		wg.Add(1)
+		go func(i int, d dependency) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, d)
+		}(i, d)
+	}
+	wg.Wait()
+
+	res := readiness{Status: statusOK, Checks: make(map[string]checkResult)}
+	for i, d := range dependencies {
+		res.Checks[d.name] = results[i]
+		if results[i].Status == statusOK || results[i].Status == statusDisabled {
+			continue
+		}
+		if d.critical {
+			res.Status = statusDown
+		} else if res.Status == statusOK {
+			res.Status = statusDegraded
+		}
+	}
+
+	status := http.StatusOK
+	if res.Status == statusDown {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(ctx, w, status, res)
+}
+
+func runCheck(ctx context.Context, d dependency) checkResult {
+	if d.disabled != nil && d.disabled() {
+		return checkResult{Status: statusDisabled}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() { errc <- d.check(ctx) }()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = errCheckTimeout
+	}
+
+	res := checkResult{Status: statusOK, LatencyMS: float64(time.Since(start)) / float64(time.Millisecond)}
+	if err != nil {
+		platform.Warningf(ctx, "%s check failed: %v", d.name, err)
+		res.Status = statusDown
+		res.Error = errCheckFailed.Error()
+		if err == errCheckTimeout {
+			res.Error = err.Error()
+		}
+	}
+	return res
+}
+
+func checkDatastore(ctx context.Context) error {
+	_, err := platform.NewQuery(eventKind).KeysOnly().Limit(1).GetAll(ctx, nil)
+	return err
+}
+
+func checkCache(ctx context.Context) error {
+	now := time.Now().UnixNano()
+	if err := platform.CacheSet(ctx, healthCacheKey, now, time.Minute); err != nil {
+		return err
+	}
+	var got int64
+	return platform.CacheGet(ctx, healthCacheKey, &got)
+}
+
+var lastWeatherCheck struct {
+	sync.Mutex
+	time time.Time
+	err  error
+}
+
+func checkWeather(ctx context.Context) error {
+	lastWeatherCheck.Lock()
+	defer lastWeatherCheck.Unlock()
+	ttl := weatherCheckTTL
+	if lastWeatherCheck.err != nil {
+		ttl = weatherRetryTTL
+	}
+	if time.Since(lastWeatherCheck.time) > ttl {
+		_, err := fetchWeather(ctx, healthLocation)
+		// Failures because the check timed out or the probe went away don't
+		// say anything about the weather API, so they're not reused.
+		if ctx.Err() != nil {
+			return err
+		}
+		lastWeatherCheck.err = err
+		lastWeatherCheck.time = time.Now()
+	}
+	return lastWeatherCheck.err
+}
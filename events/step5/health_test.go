@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestReadyzWeather(t *testing.T) {
+	f := setup(t)
+	cfg = config{WeatherAPIKey: "secret-key"}
+	lastWeatherCheck.time = time.Time{}
+
+	// Without a handler the requests to the weather API fail, with their URL
+	// in the error.
+	w := serve("GET", "/readyz", "")
+	expect(t, w, 200)
+	if body := w.Body.String(); strings.Contains(body, "secret-key") || !strings.Contains(body, `"status":"degraded"`) {
+		t.Errorf("got %s, want a degraded status without the API key", body)
+	}
+	if logs := strings.Join(f.Log.Messages(), "\n"); !strings.Contains(logs, "weather check failed") {
+		t.Errorf("the failure was not logged: %s", logs)
+	}
+
+	// Failures are retried sooner than successes.
+	f.Transport.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"weather": [{"description": "sunny", "icon": "01d"}]}`)
+	})
+	lastWeatherCheck.time = time.Now().Add(-weatherRetryTTL - time.Second)
+	w = serve("GET", "/readyz", "")
+	expect(t, w, 200)
+	if body := w.Body.String(); !strings.Contains(body, `"status":"ok"`) || strings.Contains(body, "degraded") {
+		t.Errorf("got %s, want the weather API to be ok", body)
+	}
+}
+
+func TestWeatherCheckCanceled(t *testing.T) {
+	f := setup(t)
+	cfg = config{WeatherAPIKey: "key"}
+	lastWeatherCheck.time = time.Time{}
+	lastWeatherCheck.err = nil
+
+	// The failure of a check that was canceled is not reused.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := checkWeather(ctx); err == nil {
+		t.Fatal("the canceled check didn't fail")
+	}
+	if !lastWeatherCheck.time.IsZero() || lastWeatherCheck.err != nil {
+		t.Fatalf("the failure of the canceled check was reused: %v", lastWeatherCheck.err)
+	}
+
+	f.Transport.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"weather": [{"description": "sunny", "icon": "01d"}]}`)
+	})
+	if err := checkWeather(context.Background()); err != nil {
+		t.Fatalf("the weather API is ok, got %v", err)
+	}
+}
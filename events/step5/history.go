@@ -0,0 +1,190 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	revisionKind = "Revision"
+
+	// deletedEventKind is the kind of deleted events. Deleting an event moves
+	// it to this kind keeping its id, so it can be restored later and none of
+	// the queries on events need to care about deleted ones.
+	deletedEventKind = "DeletedEvent"
+)
+
+// Actions recorded in revisions.
+const (
+	revisionCreated  = "created"
+	revisionUpdated  = "updated"
+	revisionDeleted  = "deleted"
+	revisionRestored = "restored"
+	revisionApproved = "approved"
+)
+
+// Revision records a change to an event. Revisions are stored as children
+// of the event they belong to and never modified.
+type Revision struct {
+	Action  string    `json:"action"`
+	Author  string    `json:"author"`
+	Time    time.Time `json:"time"`
+	Changes []Change  `json:"changes"`
+}
+
+// Change is the old and new value of a field of an event.
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old" datastore:",noindex"`
+	New   string `json:"new" datastore:",noindex"`
+}
+
+func getHistory(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	key, err := eventKey(ctx, calendar, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "event not found", http.StatusNotFound)
+		return
+	}
+
+	revisions := []Revision{}
+	q := platform.NewQuery(revisionKind).Ancestor(key).Order("Time")
+	if _, err := q.GetAll(ctx, &revisions); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Events that never existed have no revisions.
+	if len(revisions) == 0 {
+		http.Error(w, "event not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(ctx, w, http.StatusOK, revisions)
+}
+
+func restoreEvent(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	if readOnly(w) || !requireUser(w, r) {
+		return
+	}
+
+	key, err := eventKey(ctx, calendar, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "deleted event not found", http.StatusNotFound)
+		return
+	}
+	deleted := platform.NewKey(deletedEventKind, "", key.IntID(), calendar)
+
+	var e Event
+	err = platform.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := platform.Get(ctx, deleted, &e); err != nil {
+			return err
+		}
+		e.Updated = time.Now()
+		if _, err := platform.Put(ctx, key, &e); err != nil {
+			return err
+		}
+		if err := platform.Delete(ctx, deleted); err != nil {
+			return err
+		}
+		return addRevision(ctx, key, revisionRestored, author(r), nil, &e)
+	}, true)
+	if err == platform.ErrNoSuchEntity {
+		http.Error(w, "deleted event not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.setKey(key)
+	notifyWebhooks(ctx, eventRestored, &e)
+
+	w.Header().Set("ETag", eventETag(&e))
+	writeJSON(ctx, w, http.StatusOK, e)
+}
+
+// addRevision stores a new revision for the event with the given key,
+// recording the differences between its old and new values.
+func addRevision(ctx context.Context, key *platform.Key, action, author string, old, new *Event) error {
+	rev := newRevision(action, author, old, new)
+	if _, err := platform.Put(ctx, platform.NewIncompleteKey(revisionKind, key), rev); err != nil {
+		return fmt.Errorf("could not store revision: %v", err)
+	}
+	return nil
+}
+
+// newRevision returns the revision recording a change to an event.
+func newRevision(action, author string, old, new *Event) *Revision {
+	return &Revision{
+		Action:  action,
+		Author:  author,
+		Time:    time.Now(),
+		Changes: diffEvents(old, new),
+	}
+}
+
+// diffEvents returns the fields that differ between the two events.
+// A nil event is considered to have all its fields empty.
+func diffEvents(old, new *Event) []Change {
+	fields := func(e *Event) []string {
+		if e == nil {
+			return make([]string, 6)
+		}
+		var coordinates string
+		if p := e.coordinates(); p != nil {
+			coordinates = formatCoordinate(&p.lat) + "," + formatCoordinate(&p.lon)
+		}
+		return []string{e.Title, e.Date.Format(dateFormat), formatDays(e.Days), e.Location, coordinates, e.Description}
+	}
+	names := []string{"title", "date", "days", "location", "coordinates", "description"}
+
+	var changes []Change
+	o, n := fields(old), fields(new)
+	for i, name := range names {
+		if o[i] != n[i] {
+			changes = append(changes, Change{Field: name, Old: o[i], New: n[i]})
+		}
+	}
+	return changes
+}
+
+// Authors of the changes made without a user.
+const (
+	anonymousAuthor = "anonymous"
+	adminAuthor     = "administrator"
+)
+
+// author returns who is making the request: the email of the signed in
+// user, or adminAuthor or anonymousAuthor without one. Revisions are public,
+// so nothing else identifies anonymous users.
+func author(r *http.Request) string {
+	ctx := platform.NewContext(r)
+	if email := platform.CurrentUser(ctx); email != "" {
+		return email
+	}
+	if platform.IsAdmin(ctx) {
+		return adminAuthor
+	}
+	return anonymousAuthor
+}
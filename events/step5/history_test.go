@@ -0,0 +1,51 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestHistoryAuthors(t *testing.T) {
+	f := setup(t)
+
+	w := serve("POST", "/api/events", eventJSON("Meetup", 1))
+	expect(t, w, 201)
+	location := w.Header().Get("Location")
+	f.User = "ada@example.com"
+	w = serve("GET", location, "")
+	w = serve("PUT", location, eventJSON("Go meetup", 1), "If-Match", w.Header().Get("ETag"))
+	expect(t, w, 200)
+	f.User, f.Admin = "", true
+	expect(t, serve("DELETE", location, "", "If-Match", w.Header().Get("ETag")), 204)
+
+	w = serve("GET", location+"/history", "")
+	expect(t, w, 200)
+	var revisions []Revision
+	if err := json.Unmarshal(w.Body.Bytes(), &revisions); err != nil {
+		t.Fatal(err)
+	}
+	var authors []string
+	for _, rev := range revisions {
+		authors = append(authors, rev.Author)
+	}
+	if got, want := strings.Join(authors, ","), "anonymous,ada@example.com,administrator"; got != want {
+		t.Errorf("got authors %s, want %s", got, want)
+	}
+	if strings.Contains(w.Body.String(), "192.0.2.1") {
+		t.Errorf("the history shows the address of the anonymous user: %s", w.Body)
+	}
+}
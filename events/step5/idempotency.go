@@ -0,0 +1,267 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+const (
+	idempotencyKind   = "IdempotentRequest"
+	idempotencyHeader = "Idempotency-Key"
+
+	// idempotencyTTL is how long the response to a request is replayed to
+	// the requests with the same key.
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyTimeout is how long a request is considered in progress.
+	// After that it's assumed to have been interrupted, and a new request
+	// with the same key is handled.
+	idempotencyTimeout = time.Minute
+
+	// maxReplayedBody is the size of the largest response body stored to be
+	// replayed, since entities are limited to 1MB. Larger responses are
+	// replayed without their body.
+	maxReplayedBody = 512 << 10
+	// cleanupBatchSize is the number of expired requests deleted at a time.
+	cleanupBatchSize = 500
+)
+
+// Errors returned when a request with an Idempotency-Key can't be handled.
+var (
+	errRequestInProgress = errors.New("a request with the same Idempotency-Key is in progress, retry later")
+	errKeyReused         = errors.New("the Idempotency-Key was used for a different request")
+)
+
+// idempotentRequest is a request with an Idempotency-Key header, stored with
+// its response once it succeeded.
+type idempotentRequest struct {
+	// Fingerprint identifies the method, query and body of the request.
+	Fingerprint string `datastore:",noindex"`
+	Started     time.Time
+	// Status is zero while the request is in progress.
+	Status      int    `datastore:",noindex"`
+	ContentType string `datastore:",noindex"`
+	Location    string `datastore:",noindex"`
+	ETag        string `datastore:",noindex"`
+	Body        []byte `datastore:",noindex"`
+}
+
+// idempotent handles the requests with an Idempotency-Key header so clients
+// can safely retry them: the response to the first successful request with
+// a key is replayed to later requests from the same client with the same key
+// and path, instead of calling h again. Requests without the header are
+// passed to h.
+func idempotent(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(idempotencyHeader)
+		if id == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx := platform.NewContext(r)
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			status := http.StatusBadRequest
+			if bodyTooLarge(err) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, fmt.Sprintf("could not read body: %v", err), status)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+
+		key := idempotencyKey(r, id)
+		prev, err := startIdempotent(ctx, key, fingerprint)
+		switch {
+		case err == errRequestInProgress:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err == errKeyReused:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		case prev != nil:
+			replay(w, prev)
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rw, r)
+
+		// Only successful requests are replayed, so failed ones can be retried.
+		if rw.status >= 300 {
+			if err := platform.Delete(ctx, key); err != nil {
+				platform.Errorf(ctx, "deleting idempotent request %s: %v", key.StringID(), err)
+			}
+			return
+		}
+		req := &idempotentRequest{
+			Fingerprint: fingerprint,
+			Started:     time.Now(),
+			Status:      rw.status,
+			ContentType: w.Header().Get("Content-Type"),
+			Location:    w.Header().Get("Location"),
+			ETag:        w.Header().Get("ETag"),
+		}
+		if rw.body.Len() <= maxReplayedBody {
+			req.Body = rw.body.Bytes()
+		}
+		_, err = platform.Put(ctx, key, req)
+		if err != nil && req.Body != nil {
+			// The request must not be handled again, so it's at least
+			// replayed without its body.
+			platform.Errorf(ctx, "storing idempotent request %s, retrying without its body: %v", key.StringID(), err)
+			req.Body = nil
+			_, err = platform.Put(ctx, key, req)
+		}
+		if err != nil {
+			platform.Errorf(ctx, "storing idempotent request %s: %v", key.StringID(), err)
+		}
+	})
+}
+
+// requestFingerprint identifies the request, so a key reused for a request
+// with another method, query or body is detected. The query matters since
+// it changes what the request does, as dry_run=true for imports.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", r.Method, r.URL.RawQuery)
+	h.Write(body)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// idempotencyKey returns the key of the request with the given Idempotency-Key,
+// which is scoped to its path and to the client sending it: the signed in
+// user, the API key or, without any, the IP address. Keys are hashed since
+// they can be long, and API keys are secrets.
+func idempotencyKey(r *http.Request, id string) *platform.Key {
+	client := "ip " + clientIP(r)
+	if user := platform.CurrentUser(platform.NewContext(r)); user != "" {
+		client = "user " + user
+	} else if key := r.Header.Get(apiKeyHeader); key != "" {
+		client = "key " + key
+	}
+	name := fmt.Sprintf("%x", sha256.Sum256([]byte(client+"\n"+r.URL.Path+"\n"+id)))
+	return platform.NewKey(idempotencyKind, name, 0, nil)
+}
+
+// cleanupIdempotency deletes the idempotent requests older than
+// idempotencyTTL, which are no longer replayed. It is run daily by cron, see
+// cron.yaml.
+func cleanupIdempotency(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+	if !fromCron(w, r) {
+		return
+	}
+
+	q := platform.NewQuery(idempotencyKind).
+		Filter("Started <", time.Now().Add(-idempotencyTTL)).
+		KeysOnly().
+		Limit(cleanupBatchSize)
+	deleted := 0
+	for {
+		keys, err := q.GetAll(ctx, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := platform.DeleteMulti(ctx, keys); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		deleted += len(keys)
+		if len(keys) < cleanupBatchSize {
+			break
+		}
+	}
+	platform.Infof(ctx, "deleted %d expired idempotent requests", deleted)
+}
+
+// startIdempotent returns the stored request with the given key if it
+// succeeded. Otherwise it records that a request with the key is in progress
+// and returns nil.
+func startIdempotent(ctx context.Context, key *platform.Key, fingerprint string) (*idempotentRequest, error) {
+	var prev *idempotentRequest
+	err := platform.RunInTransaction(ctx, func(ctx context.Context) error {
+		var req idempotentRequest
+		err := platform.Get(ctx, key, &req)
+		if err != nil && err != platform.ErrNoSuchEntity {
+			return err
+		}
+		if err == nil {
+			age := time.Since(req.Started)
+			switch {
+			case req.Status == 0 && age < idempotencyTimeout:
+				return errRequestInProgress
+			case req.Status != 0 && age < idempotencyTTL && req.Fingerprint != fingerprint:
+				return errKeyReused
+			case req.Status != 0 && age < idempotencyTTL:
+				prev = &req
+				return nil
+			}
+		}
+		_, err = platform.Put(ctx, key, &idempotentRequest{Fingerprint: fingerprint, Started: time.Now()})
+		return err
+	}, false)
+	return prev, err
+}
+
+// replay writes the stored response to an idempotent request. Responses
+// too large to be stored are replayed without their body.
+func replay(w http.ResponseWriter, req *idempotentRequest) {
+	for name, value := range map[string]string{
+		"Content-Type": req.ContentType,
+		"Location":     req.Location,
+		"ETag":         req.ETag,
+	} {
+		if value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(req.Status)
+	w.Write(req.Body)
+}
+
+// recordingWriter is an http.ResponseWriter keeping a copy of the status
+// code and body of the response.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+func TestIdempotency(t *testing.T) {
+	f := setup(t)
+	event := eventJSON("Meetup", 1)
+
+	w := serve("POST", "/api/events", event, idempotencyHeader, "k1")
+	expect(t, w, 201)
+	w = serve("POST", "/api/events", event, idempotencyHeader, "k1")
+	expect(t, w, 201)
+	if w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("the response to the same client was not replayed")
+	}
+
+	// Keys are scoped to the client, so other clients don't get the
+	// response, and their event is a duplicate.
+	f.User = "ada@example.com"
+	w = serve("POST", "/api/events", event, idempotencyHeader, "k1")
+	expect(t, w, 409)
+	if w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("the response to another client was replayed")
+	}
+
+	// Expired requests are deleted by cron.
+	ctx := context.Background()
+	var reqs []idempotentRequest
+	keys, err := platform.NewQuery(idempotencyKind).GetAll(ctx, &reqs)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("got %d idempotent requests and error %v, want 1", len(keys), err)
+	}
+	expect(t, serve("GET", "/api/cron/idempotency", "", "X-Appengine-Cron", "true"), 200)
+	if n, _ := platform.NewQuery(idempotencyKind).KeysOnly().GetAll(ctx, nil); len(n) != 1 {
+		t.Errorf("got %d idempotent requests, want the recent one kept", len(n))
+	}
+	reqs[0].Started = time.Now().Add(-idempotencyTTL - time.Minute)
+	if _, err := platform.Put(ctx, keys[0], &reqs[0]); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, serve("GET", "/api/cron/idempotency", ""), 403)
+	expect(t, serve("GET", "/api/cron/idempotency", "", "X-Appengine-Cron", "true"), 200)
+	if n, _ := platform.NewQuery(idempotencyKind).KeysOnly().GetAll(ctx, nil); len(n) != 0 {
+		t.Errorf("got %d idempotent requests, want the expired one deleted", len(n))
+	}
+}
+
+func TestIdempotencyKeyReused(t *testing.T) {
+	setup(t)
+	csv := "title,date,location,description\nWorkshop,2099-01-02,Paris,\n"
+
+	// The dry run and the import have the same body, but not the same query.
+	w := serve("POST", "/api/events:import?format=csv&dry_run=true", csv, "Content-Type", "text/csv", idempotencyHeader, "k1")
+	expect(t, w, 200)
+	w = serve("POST", "/api/events:import?format=csv", csv, "Content-Type", "text/csv", idempotencyHeader, "k1")
+	expect(t, w, 422)
+	w = serve("POST", "/api/events:import?format=csv", csv, "Content-Type", "text/csv", idempotencyHeader, "k2")
+	expect(t, w, 200)
+	if w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("the import was replayed")
+	}
+
+	expect(t, serve("POST", "/api/events", eventJSON("Meetup", 1), idempotencyHeader, "k3"), 201)
+	expect(t, serve("POST", "/api/events?allow_duplicate=true", eventJSON("Meetup", 1), idempotencyHeader, "k3"), 422)
+}
+
+func TestIdempotencyBodyTooLarge(t *testing.T) {
+	setup(t)
+	// Without a Content-Length the limit is only found when reading.
+	r := httptest.NewRequest("POST", "/api/events", strings.NewReader(strings.Repeat(" ", maxBodySize+1)))
+	r.ContentLength = -1
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(idempotencyHeader, "k1")
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, r)
+	expect(t, w, http.StatusRequestEntityTooLarge)
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+// logRequests logs a structured entry for each request, with its route,
+// status, latency and error message if it failed.
+//
+// Requests are identified by their X-Request-ID header, which is assigned
+// if missing or not valid, and returned in the response. Log entries written
+// with the context of the request, and the requests sent with
+// platform.Client, carry the same id.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(platform.RequestIDHeader)
+		if !platform.ValidRequestID(id) {
+			id = platform.NewRequestID()
+			r.Header.Set(platform.RequestIDHeader, id)
+		}
+		w.Header().Set(platform.RequestIDHeader, id)
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		fields := map[string]interface{}{
+			"route":      routeOf(r),
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     sw.status,
+			"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+		}
+		severity := platform.Info
+		if sw.status >= 400 {
+			fields["error"] = strings.TrimSpace(string(sw.err))
+			severity = platform.Warning
+		}
+		if sw.status >= 500 {
+			severity = platform.Error
+		}
+		platform.Log(platform.NewContext(r), severity, r.Method+" "+r.URL.Path, fields)
+	})
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+func TestRequestID(t *testing.T) {
+	f := setup(t)
+
+	for _, tt := range []struct {
+		id   string
+		kept bool
+	}{
+		{"", false},
+		{"ed3b1644-2651-272a", true},
+		{strings.Repeat("a", 64), true},
+		{strings.Repeat("a", 65), false},
+		{"<script>alert(1)</script>", false},
+		{"id with spaces", false},
+	} {
+		w := serve("GET", "/healthz", "", platform.RequestIDHeader, tt.id)
+		expect(t, w, 200)
+		got := w.Header().Get(platform.RequestIDHeader)
+		if tt.kept && got != tt.id || !tt.kept && (got == tt.id || !platform.ValidRequestID(got)) {
+			t.Errorf("with id %q got %q in the response, kept should be %v", tt.id, got, tt.kept)
+		}
+		entries := f.Log.Entries()
+		if e := entries[len(entries)-1]; e.RequestID != got {
+			t.Errorf("with id %q got %q in the log, want %q", tt.id, e.RequestID, got)
+		}
+	}
+}
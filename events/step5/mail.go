@@ -0,0 +1,128 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// mailMessage is a plain text email sent to a single recipient.
+type mailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// A mailSender delivers emails.
+type mailSender interface {
+	Send(ctx context.Context, msg *mailMessage) error
+}
+
+// newMailSender returns the mailSender in the configuration. If there's an
+// SMTP address emails are sent through that SMTP server, otherwise the
+// default sender is used: the App Engine mail API on App Engine, or the log
+// elsewhere.
+func newMailSender(ctx context.Context) mailSender {
+	from := cfg.MailFrom
+	if from == "" {
+		from = defaultFrom(ctx)
+	}
+
+	addr := cfg.SMTPAddr
+	if addr == "" {
+		return defaultSender(from)
+	}
+
+	s := &smtpSender{addr: addr, from: from, dial: dialSocket}
+	if cfg.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		s.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+	}
+	return s
+}
+
+// smtpSender sends emails through an SMTP server, such as a local fake
+// server like MailHog while developing.
+type smtpSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+
+	// dial opens the connection to the SMTP server.
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func (s *smtpSender) Send(ctx context.Context, msg *mailMessage) error {
+	conn, err := s.dial(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %v", s.addr, err)
+	}
+	host, _, _ := net.SplitHostPort(s.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("could not start SMTP session: %v", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && s.auth != nil {
+		if err := c.StartTLS(nil); err != nil {
+			return fmt.Errorf("could not start TLS: %v", err)
+		}
+	}
+	if s.auth != nil {
+		if err := c.Auth(s.auth); err != nil {
+			return fmt.Errorf("could not authenticate: %v", err)
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.format(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// format returns the message with its headers as expected by SMTP.
+func (s *smtpSender) format(msg *mailMessage) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(msg.Body, "\n", "\r\n", -1))
+	return b.Bytes()
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build appengine
+// +build appengine
+
+package events
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/mail"
+	"google.golang.org/appengine/socket"
+)
+
+// defaultFrom returns the sender address of the application.
+func defaultFrom(ctx context.Context) string {
+	return fmt.Sprintf("events@%s.appspotmail.com", appengine.AppID(ctx))
+}
+
+// defaultSender returns a sender using the App Engine mail API.
+func defaultSender(from string) mailSender { return appengineSender{from} }
+
+// appengineSender sends emails using the App Engine mail API.
+type appengineSender struct{ from string }
+
+func (s appengineSender) Send(ctx context.Context, msg *mailMessage) error {
+	return mail.Send(ctx, &mail.Message{
+		Sender:  s.from,
+		To:      []string{msg.To},
+		Subject: msg.Subject,
+		Body:    msg.Body,
+	})
+}
+
+// dialSocket opens connections with the App Engine sockets API, since
+// the net package can't be used directly on App Engine.
+func dialSocket(ctx context.Context, network, addr string) (net.Conn, error) {
+	return socket.Dial(ctx, network, addr)
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+// +build !appengine
+
+package events
+
+import (
+	"net"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+// defaultFrom returns the sender address used when MAIL_FROM is not set.
+func defaultFrom(ctx context.Context) string { return "events@localhost" }
+
+// defaultSender returns a sender writing emails to the log, since there's
+// no mail service outside App Engine. Set SMTP_ADDR to send them.
+func defaultSender(from string) mailSender { return logSender{from} }
+
+// logSender logs emails instead of sending them.
+type logSender struct{ from string }
+
+func (s logSender) Send(ctx context.Context, msg *mailMessage) error {
+	platform.Infof(ctx, "email from %s to %s: %s\n%s", s.from, msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// dialSocket opens connections with the net package.
+func dialSocket(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/base64"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fakeSMTP is an SMTP server accepting a single session, which records the
+// commands and the message it receives.
+type fakeSMTP struct {
+	addr string
+	// reject is the recipient refused by the server, if any.
+	reject string
+
+	done     chan struct{}
+	commands []string
+	data     string
+}
+
+func startFakeSMTP(t *testing.T, reject string) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeSMTP{addr: l.Addr().String(), reject: reject, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(textproto.NewConn(conn))
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(c *textproto.Conn) {
+	c.PrintfLine("220 localhost fake SMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		s.commands = append(s.commands, line)
+		switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+		case "EHLO":
+			c.PrintfLine("250-localhost")
+			c.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			if line == "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00ada\x00secret")) {
+				c.PrintfLine("235 authenticated")
+			} else {
+				c.PrintfLine("535 invalid credentials")
+			}
+		case "RCPT":
+			if s.reject != "" && strings.Contains(line, s.reject) {
+				c.PrintfLine("550 no such user")
+			} else {
+				c.PrintfLine("250 ok")
+			}
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			b, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(b)
+			c.PrintfLine("250 queued")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("250 ok")
+		}
+	}
+}
+
+// wait waits for the session to end.
+func (s *fakeSMTP) wait() { <-s.done }
+
+func newTestSMTPSender(addr string) *smtpSender {
+	return &smtpSender{addr: addr, from: "events@example.com", dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}}
+}
+
+func TestSMTPSender(t *testing.T) {
+	srv := startFakeSMTP(t, "")
+	s := newTestSMTPSender(srv.addr)
+	s.auth = smtp.PlainAuth("", "ada", "secret", "127.0.0.1")
+
+	msg := &mailMessage{To: "grace@example.com", Subject: "Rappel: réunion", Body: "Hello\nGophers"}
+	if err := s.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	srv.wait()
+
+	commands := strings.Join(srv.commands, "\n")
+	for _, want := range []string{"AUTH PLAIN", "MAIL FROM:<events@example.com>", "RCPT TO:<grace@example.com>", "DATA", "QUIT"} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("the server didn't get %q in:\n%s", want, commands)
+		}
+	}
+	for _, want := range []string{
+		"From: events@example.com\n",
+		"To: grace@example.com\n",
+		"Subject: =?utf-8?q?Rappel:_r=C3=A9union?=\n",
+		"Content-Type: text/plain; charset=utf-8\n",
+		"\nHello\nGophers",
+	} {
+		if !strings.Contains(srv.data, want) {
+			t.Errorf("the message has no %q:\n%s", want, srv.data)
+		}
+	}
+}
+
+func TestSMTPSenderErrors(t *testing.T) {
+	srv := startFakeSMTP(t, "nobody@example.com")
+	err := newTestSMTPSender(srv.addr).Send(context.Background(), &mailMessage{To: "nobody@example.com", Subject: "Hi"})
+	if err == nil || !strings.Contains(err.Error(), "no such user") {
+		t.Errorf("got error %v, want the recipient to be refused", err)
+	}
+
+	srv = startFakeSMTP(t, "")
+	s := newTestSMTPSender(srv.addr)
+	s.auth = smtp.PlainAuth("", "ada", "wrong", "127.0.0.1")
+	err = s.Send(context.Background(), &mailMessage{To: "grace@example.com", Subject: "Hi"})
+	if err == nil || !strings.Contains(err.Error(), "could not authenticate") {
+		t.Errorf("got error %v, want the authentication to fail", err)
+	}
+
+	// Nothing listens on the address of a closed listener.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	err = newTestSMTPSender(l.Addr().String()).Send(context.Background(), &mailMessage{To: "grace@example.com"})
+	if err == nil || !strings.Contains(err.Error(), "could not connect") {
+		t.Errorf("got error %v, want the connection to fail", err)
+	}
+}
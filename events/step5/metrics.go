@@ -0,0 +1,114 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics exposed for Prometheus at /metrics. On App Engine each instance
+// keeps its own values, which are reset when the instance is restarted.
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_http_requests_total",
+		Help: "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "events_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	storeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "events_datastore_query_duration_seconds",
+		Help:    "Latency of datastore queries.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"query"})
+
+	weatherRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_weather_requests_total",
+		Help: "Number of calls to the weather API by result, ok or error.",
+	}, []string{"result"})
+	weatherDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "events_weather_request_duration_seconds",
+		Help:    "Latency of calls to the weather API.",
+		Buckets: prometheus.DefBuckets,
+	})
+	weatherCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_weather_cache_lookups_total",
+		Help: "Number of weather cache lookups by result, hit or miss.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, storeDuration,
+		weatherRequests, weatherDuration, weatherCache)
+}
+
+// instrument records the number and latency of requests to h, labeled with
+// the route template rather than the path, so ids don't create new series.
+func instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeOf(r)
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+	})
+}
+
+// maxErrorSize is the size of the error messages kept by statusWriter.
+const maxErrorSize = 200
+
+// routeOf returns the template of the route matching the request, or its
+// path if it didn't match any.
+func routeOf(r *http.Request) string {
+	if cr := mux.CurrentRoute(r); cr != nil {
+		if tmpl, err := cr.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return r.URL.Path
+}
+
+// statusWriter is an http.ResponseWriter remembering the status code and,
+// for failed requests, the beginning of the error message in the body.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	err    []byte
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status >= 400 && len(w.err) < maxErrorSize {
+		n := maxErrorSize - len(w.err)
+		if n > len(b) {
+			n = len(b)
+		}
+		w.err = append(w.err, b[:n]...)
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,426 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// pendingEventKind is the kind of the events submitted for moderation.
+	// Like deleted events, they're kept out of the Event kind so none of the
+	// queries on events need to care about them. Approving a submission
+	// creates the event with a new id.
+	pendingEventKind = "PendingEvent"
+
+	// submissionPath is the path template of a submission in the router.
+	submissionPath = "/api/admin/submissions/{calendar:[a-z0-9-]+}/{id:[0-9]+}"
+
+	// maxSubmissions is the maximum number of submissions listed at once.
+	maxSubmissions = 100
+)
+
+// Statuses of submissions. Approved submissions become events, so they
+// don't need one.
+const (
+	submissionPending  = "pending"
+	submissionRejected = "rejected"
+)
+
+// Errors returned when moderating submissions.
+var (
+	errNotPending      = errors.New("the submission was already rejected")
+	errReasonRequired  = errors.New("a reason is required to reject a submission")
+	errSignInToChange  = errors.New("sign in to change events, anonymous users can only submit new ones for moderation")
+	errSubmissionEmail = errors.New("invalid email")
+)
+
+// Submission is an event submitted by an anonymous user, waiting for
+// a moderator to approve it. Submissions are stored as children of their
+// calendar.
+type Submission struct {
+	ID          int64     `json:"id" datastore:"-"`
+	Calendar    string    `json:"calendar" datastore:"-"`
+	Title       string    `json:"title"`
+	Description string    `json:"description" datastore:",noindex"`
+	Date        time.Time `json:"date"`
+	Days        int       `json:"days,omitempty" datastore:",noindex"`
+	Location    string    `json:"location"`
+	Latitude    float64   `json:"latitude,omitempty" datastore:",noindex"`
+	Longitude   float64   `json:"longitude,omitempty" datastore:",noindex"`
+	Geohash     string    `json:"-" datastore:",noindex"`
+	Status      string    `json:"status"`
+	// Reason tells the submitter why the submission was rejected.
+	Reason string `json:"reason,omitempty" datastore:",noindex"`
+	// Submitter is the author of the submission, as in revisions, and Email
+	// the optional address where they're told about the decision.
+	Submitter string    `json:"submitter"`
+	Email     string    `json:"email,omitempty" datastore:",noindex"`
+	Submitted time.Time `json:"submitted"`
+	Updated   time.Time `json:"updated"`
+}
+
+// submissionInput is the body of a submission: an event and, optionally,
+// the email of the submitter.
+type submissionInput struct {
+	eventInput
+	Email string `json:"email"`
+}
+
+// moderated returns whether the events added by the user making the request
+// must be approved by a moderator. The administrators of the application
+// count as signed in, since outside App Engine they have no user.
+func moderated(ctx context.Context) bool {
+	return cfg.ModerateSubmissions && platform.CurrentUser(ctx) == "" && !platform.IsAdmin(ctx)
+}
+
+// requireUser checks that anonymous users are allowed to change events,
+// writing an error to the response if they're not.
+func requireUser(w http.ResponseWriter, r *http.Request) bool {
+	if moderated(platform.NewContext(r)) {
+		http.Error(w, errSignInToChange.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// setKey sets the fields of the submission identifying it from its key.
+func (s *Submission) setKey(key *platform.Key) {
+	s.ID = key.IntID()
+	s.Calendar = key.Parent().StringID()
+}
+
+// setEvent sets the fields of the submission describing the event.
+func (s *Submission) setEvent(e *Event) {
+	s.Title, s.Description, s.Date, s.Days, s.Location = e.Title, e.Description, e.Date, e.Days, e.Location
+	s.Latitude, s.Longitude, s.Geohash = e.Latitude, e.Longitude, e.Geohash
+}
+
+// event returns the event described by the submission.
+func (s *Submission) event() *Event {
+	e := &Event{Title: s.Title, Description: s.Description, Date: s.Date, Days: s.Days, Location: s.Location}
+	if s.Geohash != "" {
+		e.setCoordinates(&point{s.Latitude, s.Longitude})
+	}
+	return e
+}
+
+// submitEvent creates a submission for the event described in the request,
+// which is sent to the calendar by an anonymous user.
+func submitEvent(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	var in submissionInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	e, err := in.event()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, err := createSubmission(ctx, calendar, e, author(r), in.Email)
+	if err == errSubmissionEmail {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(ctx, w, http.StatusAccepted, s)
+}
+
+// createSubmission stores the event as a pending submission to the calendar.
+func createSubmission(ctx context.Context, calendar *platform.Key, e *Event, submitter, email string) (*Submission, error) {
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			return nil, errSubmissionEmail
+		}
+		email = addr.Address
+	}
+	now := time.Now()
+	s := &Submission{
+		Status:    submissionPending,
+		Submitter: submitter,
+		Email:     email,
+		Submitted: now,
+		Updated:   now,
+	}
+	s.setEvent(e)
+	key, err := platform.Put(ctx, platform.NewIncompleteKey(pendingEventKind, calendar), s)
+	if err != nil {
+		return nil, err
+	}
+	s.setKey(key)
+	return s, nil
+}
+
+// listSubmissions lists the oldest submissions with the status in the status
+// parameter, pending by default, in all calendars or in the one in the
+// calendar parameter.
+func listSubmissions(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	status := r.FormValue("status")
+	if status == "" {
+		status = submissionPending
+	}
+	if status != submissionPending && status != submissionRejected {
+		http.Error(w, fmt.Sprintf("status must be %s or %s", submissionPending, submissionRejected), http.StatusBadRequest)
+		return
+	}
+
+	q := platform.NewQuery(pendingEventKind).
+		Filter("Status =", status).
+		Order("Submitted").
+		Limit(maxSubmissions)
+	if name := r.FormValue("calendar"); name != "" {
+		q = q.Ancestor(calendarKey(name))
+	}
+	submissions := []Submission{}
+	keys, err := q.GetAll(ctx, &submissions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i, key := range keys {
+		submissions[i].setKey(key)
+	}
+	writeJSON(ctx, w, http.StatusOK, submissions)
+}
+
+// submissionKey returns the key of the submission in the path.
+func submissionKey(r *http.Request) (*platform.Key, error) {
+	vars := mux.Vars(r)
+	key, err := eventKey(platform.NewContext(r), calendarKey(vars["calendar"]), vars["id"])
+	if err != nil {
+		return nil, err
+	}
+	return platform.NewKey(pendingEventKind, "", key.IntID(), key.Parent()), nil
+}
+
+func getSubmission(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	key, err := submissionKey(r)
+	if err != nil {
+		http.Error(w, "submission not found", http.StatusNotFound)
+		return
+	}
+	var s Submission
+	if err := platform.Get(ctx, key, &s); err == platform.ErrNoSuchEntity {
+		http.Error(w, "submission not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.setKey(key)
+	writeJSON(ctx, w, http.StatusOK, s)
+}
+
+// updateSubmission lets moderators fix a pending submission before
+// approving it.
+func updateSubmission(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	if readOnly(w) || !requireJSON(w, r) {
+		return
+	}
+
+	key, err := submissionKey(r)
+	if err != nil {
+		http.Error(w, "submission not found", http.StatusNotFound)
+		return
+	}
+	e, err := decodeEvent(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var s Submission
+	err = platform.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := getPending(ctx, key, &s); err != nil {
+			return err
+		}
+		s.setEvent(e)
+		s.Updated = time.Now()
+		_, err := platform.Put(ctx, key, &s)
+		return err
+	}, false)
+	if !moderationError(w, err) {
+		return
+	}
+	s.setKey(key)
+	writeJSON(ctx, w, http.StatusOK, s)
+}
+
+// approveSubmission creates the event in a pending submission, and tells
+// the submitter it's published.
+func approveSubmission(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	if readOnly(w) {
+		return
+	}
+
+	key, err := submissionKey(r)
+	if err != nil {
+		http.Error(w, "submission not found", http.StatusNotFound)
+		return
+	}
+
+	allowDuplicate := r.FormValue("allow_duplicate") == "true"
+	var s Submission
+	var e *Event
+	err = platform.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := getPending(ctx, key, &s); err != nil {
+			return err
+		}
+		e = s.event()
+		if err := insertEvent(ctx, key.Parent(), e, s.Submitter, allowDuplicate); err != nil {
+			return err
+		}
+		eventKey := platform.NewKey(eventKind, "", e.ID, key.Parent())
+		if err := addRevision(ctx, eventKey, revisionApproved, author(r), nil, nil); err != nil {
+			return err
+		}
+		return platform.Delete(ctx, key)
+	}, false)
+	if err == errDuplicateEvent {
+		w.Header().Set("Location", eventPath(e))
+		http.Error(w, err.Error()+", set allow_duplicate to approve it anyway", http.StatusConflict)
+		return
+	}
+	if !moderationError(w, err) {
+		return
+	}
+	notifyWebhooks(ctx, eventCreated, e)
+
+	if s.Email != "" {
+		notifySubmitter(ctx, &mailMessage{
+			To:      s.Email,
+			Subject: fmt.Sprintf("Your event %q is published", e.Title),
+			Body: fmt.Sprintf("Hi,\n\nThanks for submitting %q, it's now published at:\n\n%s\n",
+				e.Title, absURL(r, eventPath(e), "")),
+		})
+	}
+
+	w.Header().Set("Location", eventPath(e))
+	w.Header().Set("ETag", eventETag(e))
+	writeJSON(ctx, w, http.StatusCreated, e)
+}
+
+// rejectSubmission marks a pending submission as rejected for the reason in
+// the body, and tells the submitter why. Rejected submissions are kept, so
+// moderators can find the previous decisions.
+func rejectSubmission(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	if readOnly(w) || !requireJSON(w, r) {
+		return
+	}
+
+	key, err := submissionKey(r)
+	if err != nil {
+		http.Error(w, "submission not found", http.StatusNotFound)
+		return
+	}
+	var data struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(data.Reason) == "" {
+		http.Error(w, errReasonRequired.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var s Submission
+	err = platform.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := getPending(ctx, key, &s); err != nil {
+			return err
+		}
+		s.Status, s.Reason, s.Updated = submissionRejected, data.Reason, time.Now()
+		_, err := platform.Put(ctx, key, &s)
+		return err
+	}, false)
+	if !moderationError(w, err) {
+		return
+	}
+	s.setKey(key)
+
+	if s.Email != "" {
+		notifySubmitter(ctx, &mailMessage{
+			To:      s.Email,
+			Subject: fmt.Sprintf("Your event %q was not published", s.Title),
+			Body: fmt.Sprintf("Hi,\n\nThanks for submitting %q. Sorry, it won't be published:\n\n%s\n",
+				s.Title, s.Reason),
+		})
+	}
+	writeJSON(ctx, w, http.StatusOK, s)
+}
+
+// getPending fetches the submission with the given key, returning
+// errNotPending if it was rejected.
+func getPending(ctx context.Context, key *platform.Key, s *Submission) error {
+	if err := platform.Get(ctx, key, s); err != nil {
+		return err
+	}
+	if s.Status != submissionPending {
+		return errNotPending
+	}
+	return nil
+}
+
+// moderationError writes the error returned when moderating a submission
+// to the response, returning true if there was none.
+func moderationError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == platform.ErrNoSuchEntity:
+		http.Error(w, "submission not found", http.StatusNotFound)
+	case err == errNotPending:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// notifySubmitter sends an email about a submission. The decision is made
+// even if the email can't be sent, so errors are only logged.
+func notifySubmitter(ctx context.Context, msg *mailMessage) {
+	if err := newMailSender(ctx).Send(ctx, msg); err != nil {
+		platform.Errorf(ctx, "sending email to submitter %s: %v", msg.To, err)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestModerationAdmin(t *testing.T) {
+	f := setup(t)
+	cfg.ModerateSubmissions = true
+
+	// Anonymous users submit events and can't change them.
+	expect(t, serve("POST", "/api/events", eventJSON("Submitted", 1)), 202)
+	f.User = "ada@example.com"
+	w := serve("POST", "/api/events", eventJSON("Meetup", 1))
+	expect(t, w, 201)
+	location := w.Header().Get("Location")
+	f.User = ""
+	w = serve("GET", location, "")
+	expect(t, serve("DELETE", location, "", "If-Match", w.Header().Get("ETag")), 403)
+
+	// Outside App Engine the administrators, authenticated by the admin
+	// token, have no user but are not moderated.
+	f.Admin = true
+	expect(t, serve("POST", "/api/events", eventJSON("Party", 2)), 201)
+	w = serve("GET", location, "")
+	w = serve("PUT", location, eventJSON("Go meetup", 1), "If-Match", w.Header().Get("ETag"))
+	expect(t, w, 200)
+	expect(t, serve("DELETE", location, "", "If-Match", w.Header().Get("ETag")), 204)
+	expect(t, serve("POST", location+"/restore", ""), 200)
+
+	// They can also change the events of calendars with admins.
+	f.User = "ada@example.com"
+	expect(t, serve("POST", "/api/calendars", `{"name": "gophers", "title": "Gophers", "admins": ["ada@example.com"]}`), 201)
+	f.User = ""
+	expect(t, serve("POST", "/api/calendars/gophers/events", eventJSON("Workshop", 3)), 201)
+
+	if got, want := titles(t, serve("GET", "/api/events", "")), "Go meetup,Party"; got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+}
+
+// submission decodes the submission in the response.
+func submission(t *testing.T, w *httptest.ResponseRecorder) *Submission {
+	t.Helper()
+	var s Submission
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("could not decode submission: %v: %s", err, w.Body.String())
+	}
+	return &s
+}
+
+func TestModerationDecisions(t *testing.T) {
+	f := setup(t)
+	cfg.ModerateSubmissions = true
+
+	submit := func(title, email string) string {
+		body := strings.TrimSuffix(eventJSON(title, 1), "}") + fmt.Sprintf(`, "email": %q}`, email)
+		w := serve("POST", "/api/events", body)
+		expect(t, w, 202)
+		s := submission(t, w)
+		return fmt.Sprintf("/api/admin/submissions/%s/%d", s.Calendar, s.ID)
+	}
+	meetup := submit("Meetup", "grace@example.com")
+	spam := submit("Spam", "eve@example.com")
+	f.Admin = true
+
+	// Approving a submission publishes its event, and tells the submitter.
+	w := serve("POST", meetup+":approve", "")
+	expect(t, w, 201)
+	if got, want := titles(t, serve("GET", "/api/events", "")), "Meetup"; got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+	expect(t, serve("GET", meetup, ""), 404)
+	expect(t, serve("POST", meetup+":approve", ""), 404)
+
+	// Rejections need a reason, which is sent to the submitter.
+	expect(t, serve("POST", spam+":reject", `{"reason": " "}`), 400)
+	w = serve("POST", spam+":reject", `{"reason": "not an event"}`)
+	expect(t, w, 200)
+	if s := submission(t, w); s.Status != submissionRejected || s.Reason != "not an event" {
+		t.Errorf("got status %q and reason %q, want rejected for not being an event", s.Status, s.Reason)
+	}
+	expect(t, serve("POST", spam+":reject", `{"reason": "spam"}`), 409)
+	expect(t, serve("POST", spam+":approve", ""), 409)
+	expect(t, serve("PUT", spam, eventJSON("Not spam", 1)), 409)
+	if got, want := titles(t, serve("GET", "/api/admin/submissions?status=rejected", "")), "Spam"; got != want {
+		t.Errorf("got rejected submissions %s, want %s", got, want)
+	}
+
+	logs := strings.Join(f.Log.Messages(), "\n")
+	for _, want := range []string{
+		`to grace@example.com: Your event "Meetup" is published`,
+		`to eve@example.com: Your event "Spam" was not published`,
+		"not an event",
+	} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("no email with %q in the logs:\n%s", want, logs)
+		}
+	}
+}
@@ -0,0 +1,586 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// operation documents a route registered in the router.
+type operation struct {
+	summary string
+	params  []param
+	// request and response are values whose type describes the body,
+	// nil if there's no body.
+	request  interface{}
+	response interface{}
+	// status is the status code of successful responses.
+	status int
+	// errors are the status codes of the possible error responses.
+	errors []int
+	// hidden operations are not meant to be used by clients, such as
+	// the ones called by cron or the task queue.
+	hidden bool
+}
+
+// param is a query or header parameter of an operation.
+type param struct {
+	name        string
+	in          string
+	description string
+	required    bool
+}
+
+// idempotencyKeyParam documents the header handled by idempotent.
+var idempotencyKeyParam = param{
+	name:        idempotencyHeader,
+	in:          "header",
+	description: "Identifies the request, so retries with the same key get the response to the first one instead of repeating it.",
+}
+
+// formatParam documents the parameter handled by negotiate.
+var formatParam = param{
+	name:        "format",
+	in:          "query",
+	description: "json, xml, ndjson, csv or ics, by default negotiated with the Accept header.",
+}
+
+// limitParam and cursorParam document the parameters handled by
+// serveEventPage, limitParam also by listNearbyEvents.
+var (
+	limitParam = param{
+		name:        "limit",
+		in:          "query",
+		description: "The number of events in a page, or listed near a point, 10 by default and at most 100.",
+	}
+	cursorParam = param{
+		name:        "cursor",
+		in:          "query",
+		description: "The position of the page, as given in the Link header of the previous one.",
+	}
+)
+
+// apiKeyParam documents the header identifying clients in limitRate.
+var apiKeyParam = param{
+	name:        apiKeyHeader,
+	in:          "header",
+	description: "Identifies the client, so it's rate limited on its own rather than by IP address.",
+}
+
+// captchaParam documents the header handled by checkCaptcha.
+var captchaParam = param{
+	name:        captchaHeader,
+	in:          "header",
+	description: "The response to the CAPTCHA, required from anonymous users if there's one.",
+}
+
+// apiDocs documents every route in the router, indexed by method and path
+// template. checkDocs makes sure there are no routes missing.
+var apiDocs = map[string]operation{
+	"GET /": {hidden: true},
+	"GET /api/events": {
+		summary: "Lists the upcoming events with the current weather for their location. With include_past, lists all the events from the first one without weather, a page at a time.",
+		params: []param{
+			formatParam,
+			{name: "include_past", in: "query", description: "If true past events are listed too, paginated with limit and cursor."},
+			limitParam,
+			cursorParam,
+			{name: "near", in: "query", description: "A latitude and longitude, such as 37.78,-122.41, to list only the events around it sorted by distance."},
+			{name: "radius", in: "query", description: "The distance from near of the events listed, such as 50km, 800m or 10mi. By default 50km and at most 1000km."},
+		},
+		response: []Event{},
+		status:   http.StatusOK,
+		errors: []int{http.StatusNotModified, http.StatusBadRequest, http.StatusNotAcceptable,
+			http.StatusInternalServerError},
+	},
+	"POST /api/events": {
+		summary: "Creates a new event, unless there's one with the same title, date and location. If submissions are moderated, events from anonymous users are held for moderation instead, with status 202.",
+		params: []param{
+			idempotencyKeyParam,
+			captchaParam,
+			{name: "allow_duplicate", in: "query", description: "If true the event is created even if there's one with the same title, date and location."},
+		},
+		request:  eventInput{},
+		response: Event{},
+		status:   http.StatusCreated,
+		errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict,
+			http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusInternalServerError,
+			http.StatusBadGateway},
+	},
+	"POST /api/events:import": {
+		summary: "Imports events from CSV or JSON lines, reporting the errors found in each row. Events are created as with POST /api/events, skipping duplicates and notifying webhooks.",
+		params: []param{
+			idempotencyKeyParam,
+			captchaParam,
+			{name: "format", in: "query", description: "csv or jsonl, by default guessed from the Content-Type."},
+			{name: "dry_run", in: "query", description: "If true the rows are only validated."},
+			{name: "allow_duplicate", in: "query", description: "If true the events are imported even if there are others with the same title, date and location."},
+		},
+		response: importReport{},
+		status:   http.StatusOK,
+		errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict,
+			http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusInternalServerError},
+	},
+	"GET /api/events:export": {
+		summary: "Exports all the events, past and future, as CSV or JSON lines.",
+		params: []param{
+			{name: "format", in: "query", description: "csv or jsonl, by default guessed from the Accept header."},
+		},
+		status: http.StatusOK,
+		errors: []int{http.StatusBadRequest},
+	},
+	"GET /api/events/calendar": {
+		summary: "Lists the events on each day of a month or week, by default the current month in the time zone of the calendar. Events lasting several days are listed on each of them.",
+		params: []param{
+			formatParam,
+			{name: "month", in: "query", description: "A month in the YYYY-MM format, such as 2026-11."},
+			{name: "week", in: "query", description: "An ISO 8601 week in the YYYY-Www format, such as 2026-W45. Weeks start on Monday."},
+		},
+		response: calendarView{},
+		status:   http.StatusOK,
+		errors: []int{http.StatusNotModified, http.StatusBadRequest, http.StatusNotAcceptable,
+			http.StatusInternalServerError},
+	},
+	"GET /api/events/archive": {
+		summary: "Lists the past events, the most recent first, a page at a time. The Link header of each page has the URL of the next one, if any.",
+		params: []param{
+			formatParam,
+			{name: "year", in: "query", description: "A year in the YYYY format, such as 2025, to list only its events."},
+			{name: "month", in: "query", description: "A month in the YYYY-MM format, such as 2025-11, to list only its events."},
+			limitParam,
+			cursorParam,
+		},
+		response: []Event{},
+		status:   http.StatusOK,
+		errors: []int{http.StatusNotModified, http.StatusBadRequest, http.StatusNotAcceptable,
+			http.StatusInternalServerError},
+	},
+	"GET /api/events/{id}": {
+		summary:  "Returns an event with the current weather for its location.",
+		params:   []param{formatParam},
+		response: Event{},
+		status:   http.StatusOK,
+		errors: []int{http.StatusNotModified, http.StatusBadRequest, http.StatusNotFound,
+			http.StatusNotAcceptable, http.StatusInternalServerError},
+	},
+	"PUT /api/events/{id}": {
+		summary: "Updates an event.",
+		params: []param{
+			{name: "If-Match", in: "header", description: "The ETag of the event as last seen.", required: true},
+		},
+		request:  eventInput{},
+		response: Event{},
+		status:   http.StatusOK,
+		errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound,
+			http.StatusPreconditionFailed, http.StatusUnsupportedMediaType, http.StatusPreconditionRequired,
+			http.StatusInternalServerError},
+	},
+	"DELETE /api/events/{id}": {
+		summary: "Deletes an event, it can be restored later.",
+		params: []param{
+			{name: "If-Match", in: "header", description: "The ETag of the event as last seen.", required: true},
+		},
+		status: http.StatusNoContent,
+		errors: []int{http.StatusForbidden, http.StatusNotFound,
+			http.StatusPreconditionFailed, http.StatusPreconditionRequired, http.StatusInternalServerError},
+	},
+	"GET /api/events/{id}/history": {
+		summary:  "Lists the changes to an event, oldest first.",
+		response: []Revision{},
+		status:   http.StatusOK,
+		errors:   []int{http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"POST /api/events/{id}/restore": {
+		summary:  "Restores a deleted event.",
+		response: Event{},
+		status:   http.StatusOK,
+		errors:   []int{http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"GET /api/calendars": {
+		summary:  "Lists the calendars, including the default one.",
+		response: []Calendar{},
+		status:   http.StatusOK,
+		errors:   []int{http.StatusInternalServerError},
+	},
+	"POST /api/calendars": {
+		summary:  "Creates a calendar, for signed in users. Whoever creates it is its admin, unless admins are given.",
+		request:  Calendar{},
+		response: Calendar{},
+		status:   http.StatusCreated,
+		errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict,
+			http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+	},
+	"GET /api/calendars/{calendar}": {
+		summary:  "Returns the settings of a calendar.",
+		response: Calendar{},
+		status:   http.StatusOK,
+		errors:   []int{http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"PUT /api/calendars/{calendar}": {
+		summary:  "Changes the settings of a calendar. Only for the admins of the calendar, or of the application for the default one.",
+		request:  Calendar{},
+		response: Calendar{},
+		status:   http.StatusOK,
+		errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound,
+			http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+	},
+	"POST /api/subscribe": {
+		summary: "Subscribes an email to reminders and the weekly digest, sending a confirmation email.",
+		request: struct {
+			Email string `json:"email"`
+		}{},
+		status: http.StatusAccepted,
+		errors: []int{http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+	},
+	"GET /api/subscribe/confirm": {
+		summary: "Confirms a subscription.",
+		params:  []param{{name: "token", in: "query", description: "The token sent by email.", required: true}},
+		status:  http.StatusOK,
+		errors:  []int{http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"GET /api/unsubscribe": {
+		summary: "Removes a subscription.",
+		params:  []param{{name: "token", in: "query", description: "The token sent by email.", required: true}},
+		status:  http.StatusOK,
+		errors:  []int{http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"GET /api/cron/reminders":   {hidden: true},
+	"GET /api/cron/digest":      {hidden: true},
+	"GET /api/cron/idempotency": {hidden: true},
+	"GET /api/webhooks": {
+		summary:  "Lists the registered webhooks. Only for administrators.",
+		response: []Webhook{},
+		status:   http.StatusOK,
+		errors:   []int{http.StatusInternalServerError},
+	},
+	"POST /api/webhooks": {
+		summary:  "Registers a webhook, the response contains its secret. Only for administrators.",
+		request:  Webhook{},
+		response: Webhook{},
+		status:   http.StatusCreated,
+		errors:   []int{http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+	},
+	"DELETE /api/webhooks/{id}": {
+		summary: "Deletes a webhook. Only for administrators.",
+		status:  http.StatusNoContent,
+		errors:  []int{http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"GET /api/webhooks/{id}/deliveries": {
+		summary:  "Lists the latest deliveries to a webhook. Only for administrators.",
+		response: []Delivery{},
+		status:   http.StatusOK,
+		errors:   []int{http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"GET /api/admin/config": {
+		summary:  "Shows the configuration in use, with secrets redacted. Only for administrators.",
+		response: map[string]interface{}{},
+		status:   http.StatusOK,
+	},
+	"GET /api/admin/submissions": {
+		summary: "Lists the oldest events submitted for moderation. Only for administrators.",
+		params: []param{
+			{name: "status", in: "query", description: "pending, by default, or rejected."},
+			{name: "calendar", in: "query", description: "The name of the calendar of the submissions, all calendars by default."},
+		},
+		response: []Submission{},
+		status:   http.StatusOK,
+		errors:   []int{http.StatusBadRequest, http.StatusInternalServerError},
+	},
+	"GET /api/admin/submissions/{calendar}/{id}": {
+		summary:  "Returns an event submitted for moderation. Only for administrators.",
+		response: Submission{},
+		status:   http.StatusOK,
+		errors:   []int{http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"PUT /api/admin/submissions/{calendar}/{id}": {
+		summary:  "Changes a pending submission before approving it. Only for administrators.",
+		request:  eventInput{},
+		response: Submission{},
+		status:   http.StatusOK,
+		errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict,
+			http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+	},
+	"POST /api/admin/submissions/{calendar}/{id}:approve": {
+		summary: "Publishes the event in a pending submission, and emails the submitter. Only for administrators.",
+		params: []param{
+			{name: "allow_duplicate", in: "query", description: "If true the event is created even if there's one with the same title, date and location."},
+		},
+		response: Event{},
+		status:   http.StatusCreated,
+		errors: []int{http.StatusForbidden, http.StatusNotFound, http.StatusConflict,
+			http.StatusInternalServerError},
+	},
+	"POST /api/admin/submissions/{calendar}/{id}:reject": {
+		summary: "Rejects a pending submission for the given reason, and emails it to the submitter. Only for administrators.",
+		request: struct {
+			Reason string `json:"reason"`
+		}{},
+		response: Submission{},
+		status:   http.StatusOK,
+		errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict,
+			http.StatusUnsupportedMediaType, http.StatusInternalServerError},
+	},
+	"POST /api/tasks/deliver": {hidden: true},
+	"GET /api/openapi.json":   {hidden: true},
+	"GET /metrics":            {hidden: true},
+	"GET /healthz": {
+		summary:  "Reports that the application is alive.",
+		response: map[string]string{},
+		status:   http.StatusOK,
+	},
+	"GET /readyz": {
+		summary:  "Checks the dependencies of the application, and reports whether it's ok, degraded or down.",
+		response: readiness{},
+		status:   http.StatusOK,
+		errors:   []int{http.StatusServiceUnavailable},
+	},
+	"POST /api/graphql": {
+		summary: "GraphQL endpoint to query and create events, see graphql.go for the schema.",
+		params:  []param{captchaParam},
+		request: struct {
+			Query         string                 `json:"query"`
+			OperationName string                 `json:"operationName"`
+			Variables     map[string]interface{} `json:"variables"`
+		}{},
+		status: http.StatusOK,
+		errors: []int{http.StatusUnsupportedMediaType},
+	},
+}
+
+// openAPISpec is the encoded OpenAPI document, built by init.
+var openAPISpec []byte
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// muxVar matches the variables in mux path templates, such as {id:[0-9]+}.
+var muxVar = regexp.MustCompile(`\{([^}:]+)(:[^}]+)?\}`)
+
+// checkDocs returns an error unless every route in the router is documented
+// in docs and every operation in docs corresponds to a route.
+func checkDocs(r *mux.Router, docs map[string]operation) error {
+	seen := make(map[string]bool)
+	var missing []string
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return fmt.Errorf("route %s has no methods", tmpl)
+		}
+		for _, m := range methods {
+			name := m + " " + muxVar.ReplaceAllString(tmpl, "{$1}")
+			if _, ok := docs[name]; !ok {
+				missing = append(missing, name)
+			}
+			seen[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var unknown []string
+	for name := range docs {
+		if !seen[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(missing) > 0 || len(unknown) > 0 {
+		sort.Strings(missing)
+		sort.Strings(unknown)
+		return fmt.Errorf("API docs out of sync with the router: undocumented routes %v, unknown routes %v", missing, unknown)
+	}
+	return nil
+}
+
+// buildOpenAPI returns the OpenAPI 3 document describing the given operations.
+func buildOpenAPI(docs map[string]operation) ([]byte, error) {
+	s := schemas{}
+	paths := map[string]map[string]interface{}{}
+	for name, op := range docs {
+		if op.hidden {
+			continue
+		}
+		method, path := splitOperation(name)
+		// Requests changing data go through limitRate and limitBody.
+		if method != "GET" && !unlimited(path) {
+			op.params = append(op.params[:len(op.params):len(op.params)], apiKeyParam)
+			for _, code := range []int{http.StatusUnauthorized, http.StatusRequestEntityTooLarge, http.StatusTooManyRequests} {
+				op.errors = withStatus(op.errors, code)
+			}
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(method)] = s.operation(path, op)
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Events API",
+			"description": "Events with weather information for each of them.",
+			"version":     "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": s},
+	}, "", "  ")
+}
+
+func splitOperation(name string) (method, path string) {
+	i := strings.Index(name, " ")
+	return name[:i], name[i+1:]
+}
+
+// schemas contains the schemas of the named types used in the API.
+type schemas map[string]interface{}
+
+func (s schemas) operation(path string, op operation) map[string]interface{} {
+	var params []interface{}
+	for _, m := range muxVar.FindAllStringSubmatch(path, -1) {
+		// Ids are numbers, and names such as the calendar's are strings.
+		schema := map[string]interface{}{"type": "string"}
+		if m[1] == "id" {
+			schema = map[string]interface{}{"type": "integer", "format": "int64"}
+		}
+		params = append(params, map[string]interface{}{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   schema,
+		})
+	}
+	for _, p := range op.params {
+		params = append(params, map[string]interface{}{
+			"name":        p.name,
+			"in":          p.in,
+			"description": p.description,
+			"required":    p.required,
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+
+	ok := map[string]interface{}{"description": http.StatusText(op.status)}
+	if op.response != nil {
+		ok["content"] = s.content(op.response)
+	}
+	responses := map[string]interface{}{strconv.Itoa(op.status): ok}
+	for _, code := range op.errors {
+		res := map[string]interface{}{"description": http.StatusText(code)}
+		if code != http.StatusNotModified {
+			res["content"] = map[string]interface{}{
+				"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		}
+		responses[strconv.Itoa(code)] = res
+	}
+
+	res := map[string]interface{}{
+		"summary":   op.summary,
+		"responses": responses,
+	}
+	if params != nil {
+		res["parameters"] = params
+	}
+	if op.request != nil {
+		res["requestBody"] = map[string]interface{}{"required": true, "content": s.content(op.request)}
+	}
+	return res
+}
+
+func (s schemas) content(v interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": s.schema(reflect.TypeOf(v))},
+	}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// schema returns the schema for the given type, as encoded by encoding/json.
+// Named structs are added to the schemas and referenced.
+func (s schemas) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.schema(t.Elem())
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := s[name]; !ok {
+			s[name] = nil // avoids infinite recursion on recursive types.
+			s[name] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	panic(fmt.Sprintf("no schema for type %v", t))
+}
+
+func (s schemas) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = s.schema(f.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
@@ -0,0 +1,227 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+// csrfCookie is the cookie and the form field with the token that must be
+// sent with the form in the events page, so other sites can't send it.
+const csrfCookie = "csrf_token"
+
+var errCSRF = errors.New("the form expired, please send it again")
+
+var (
+	indexOnce sync.Once
+	indexTmpl *template.Template
+	indexErr  error
+)
+
+// loadIndexTmpl returns the template of the events page. It uses [[ and ]] as
+// delimiters since the page also contains AngularJS templates. The asset
+// function returns the path of a static file, fingerprinted when served by
+// StaticHandler. The template is parsed the first time the page is rendered,
+// relative to the directory of the application.
+func loadIndexTmpl() (*template.Template, error) {
+	indexOnce.Do(func() {
+		indexTmpl, indexErr = template.New("index.html").
+			Delims("[[", "]]").
+			Funcs(template.FuncMap{"asset": assetPath}).
+			ParseFiles("templates/index.html")
+	})
+	return indexTmpl, indexErr
+}
+
+// pageData is the data used to render the events page.
+type pageData struct {
+	Events []Event
+	// Error and Form are set when a submitted form was not valid.
+	Error string
+	Form  eventInput
+	// Moderated is set when the events added by the user are moderated, and
+	// Submitted once they've sent one.
+	Moderated bool
+	Submitted bool
+	// Captcha is the CAPTCHA to solve to add events, if any.
+	Captcha *captchaWidget
+	// CSRFToken is the token sent with the form, also set in a cookie.
+	CSRFToken string
+}
+
+// showEvents renders the events page, so it can be read without JavaScript.
+func showEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+	events, err := upcomingEvents(ctx, calendarKey(defaultCalendar))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderPage(w, r, http.StatusOK, &pageData{Events: events, Moderated: moderated(ctx)})
+}
+
+// addEventFromForm creates the event sent with the form in the events page
+// and takes the browser back to it. If the event is not valid the page is
+// shown again with the error and the submitted values.
+func addEventFromForm(w http.ResponseWriter, r *http.Request, calendar *platform.Key) {
+	ctx := platform.NewContext(r)
+
+	// Bots are answered as if their event was created, so they don't retry.
+	if isBot(r) {
+		platform.Infof(ctx, "ignoring event from bot %s", clientIP(r))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	in := eventInput{
+		Title:       r.FormValue("title"),
+		Date:        r.FormValue("date"),
+		Location:    r.FormValue("location"),
+		Description: r.FormValue("description"),
+	}
+	e, err := in.event()
+	if err == nil {
+		err = checkCSRF(r)
+	}
+	if err == nil {
+		err = verifyCaptcha(r)
+	}
+	if err != nil {
+		events, lerr := upcomingEvents(ctx, calendar)
+		if lerr != nil {
+			platform.Errorf(ctx, "fetching events: %v", lerr)
+		}
+		status := http.StatusBadRequest
+		if err == errCSRF {
+			status = http.StatusForbidden
+		}
+		renderPage(w, r, status, &pageData{Events: events, Error: err.Error(), Form: in, Moderated: moderated(ctx)})
+		return
+	}
+
+	if moderated(ctx) {
+		addSubmissionFromForm(w, r, calendar, e, in)
+		return
+	}
+
+	// A form sent twice, usually by clicking twice, creates a single event.
+	if err := createEvent(ctx, calendar, e, author(r), false); err != nil && err != errDuplicateEvent {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// addSubmissionFromForm submits the event sent with the form by an anonymous
+// user for moderation, and shows the page again telling them so.
+func addSubmissionFromForm(w http.ResponseWriter, r *http.Request, calendar *platform.Key, e *Event, in eventInput) {
+	ctx := platform.NewContext(r)
+
+	data := &pageData{Moderated: true}
+	_, err := createSubmission(ctx, calendar, e, author(r), r.FormValue("email"))
+	if err == errSubmissionEmail {
+		data.Error, data.Form = err.Error(), in
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.Submitted = err == nil
+
+	if data.Events, err = upcomingEvents(ctx, calendar); err != nil {
+		platform.Errorf(ctx, "fetching events: %v", err)
+	}
+	status := http.StatusAccepted
+	if data.Error != "" {
+		status = http.StatusBadRequest
+	}
+	renderPage(w, r, status, data)
+}
+
+// checkCSRF returns errCSRF unless the form has the token in the cookie set
+// with the page.
+func checkCSRF(r *http.Request) error {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.FormValue(csrfCookie))) != 1 {
+		return errCSRF
+	}
+	return nil
+}
+
+// renderPage renders the events page with the given status, setting the
+// cookie with the CSRF token unless the browser already has one.
+func renderPage(w http.ResponseWriter, r *http.Request, status int, data *pageData) {
+	tmpl, err := loadIndexTmpl()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data.Captcha = newCaptchaWidget(r)
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		data.CSRFToken = c.Value
+	} else {
+		if data.CSRFToken, err = newToken(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: data.CSRFToken, Path: "/", HttpOnly: true})
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	b.WriteTo(w)
+}
+
+// isForm returns whether the body of the request is an HTML form.
+func isForm(r *http.Request) bool {
+	t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return t == "application/x-www-form-urlencoded"
+}
+
+// requireJSON checks the body of the request is JSON, writing an error to the
+// response if it's not. Other sites can make browsers send forms to the API
+// with the cookies of the user, but only with the media types of forms, such
+// as text/plain, so those are refused.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	return requireType(w, r, "application/json")
+}
+
+// requireType checks the media type of the body of the request is one of
+// types, writing an error to the response if it's not.
+func requireType(w http.ResponseWriter, r *http.Request, types ...string) bool {
+	t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for _, typ := range types {
+		if t == typ {
+			return true
+		}
+	}
+	msg := fmt.Sprintf("unsupported content type %q, use %s", t, strings.Join(types, " or "))
+	http.Error(w, msg, http.StatusUnsupportedMediaType)
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddEventFromForm(t *testing.T) {
+	setup(t)
+
+	w := serve("GET", "/", "")
+	expect(t, w, 200)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie || cookies[0].Value == "" {
+		t.Fatalf("got cookies %v, want the CSRF token", cookies)
+	}
+	token := cookies[0].Value
+	if !strings.Contains(w.Body.String(), `name="csrf_token" value="`+token+`"`) {
+		t.Fatalf("the form has no CSRF token:\n%s", w.Body.String())
+	}
+
+	form := func(title, date, token string) string {
+		return url.Values{"title": {title}, "date": {date}, "location": {"Paris"}, "csrf_token": {token}}.Encode()
+	}
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(dateFormat)
+	post := func(body string) *httptest.ResponseRecorder {
+		return serve("POST", "/api/events", body,
+			"Content-Type", "application/x-www-form-urlencoded",
+			"Cookie", csrfCookie+"="+token)
+	}
+
+	// Forms sent from other sites don't have the token.
+	w = serve("POST", "/api/events", form("Meetup", tomorrow, ""), "Content-Type", "application/x-www-form-urlencoded")
+	expect(t, w, 403)
+	w = post(form("Meetup", tomorrow, "other"))
+	expect(t, w, 403)
+
+	// The values of a form that's not valid are shown again, out of the
+	// reach of AngularJS.
+	w = post(form("{{constructor.constructor('alert(1)')()}}", "", token))
+	expect(t, w, 400)
+	body := w.Body.String()
+	if !strings.Contains(body, `<form method="post" action="/api/events" ng-non-bindable>`) {
+		t.Errorf("the form with the submitted values is bound by AngularJS:\n%s", body)
+	}
+
+	w = post(form("Meetup", tomorrow, token))
+	expect(t, w, 303)
+	w = serve("GET", "/api/events", "")
+	expect(t, w, 200)
+	if !strings.Contains(w.Body.String(), `"title":"Meetup"`) {
+		t.Errorf("the event was not created: %s", w.Body.String())
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	f := setup(t)
+	f.User = "ada@example.com"
+
+	// Other sites can send forms as text/plain, with JSON in them.
+	for _, target := range []string{"/api/events", "/api/calendars", "/api/subscribe", "/api/graphql"} {
+		w := serve("POST", target, `{"title": "Meetup"}`, "Content-Type", "text/plain")
+		expect(t, w, 415)
+	}
+	w := serve("POST", "/api/events:import?format=jsonl", eventJSON("Meetup", 1), "Content-Type", "text/plain")
+	expect(t, w, 415)
+
+	w = serve("POST", "/api/events", eventJSON("Meetup", 1), "Content-Type", "application/json; charset=utf-8")
+	expect(t, w, 201)
+	w = serve("POST", "/api/events:import", eventJSON("Party", 1), "Content-Type", "application/x-ndjson")
+	expect(t, w, 200)
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+var (
+	reminderTmpl = template.Must(template.New("reminder").Parse(`Hi,
+
+This is a reminder that {{.Event.Title}} takes place tomorrow, {{.Event.Date.Format "Monday, January 2"}}, in {{.Event.Location}}.
+{{with .Event.Weather}}
+The weather there right now: {{.Description}}.
+{{end}}
+{{.Event.Description}}
+
+To stop receiving these emails visit {{.UnsubscribeURL}}
+`))
+
+	digestTmpl = template.Must(template.New("digest").Parse(`Hi,
+
+These are the events coming up this week:
+{{range .Events}}
+* {{.Title}}, {{.Date.Format "Monday, January 2"}} in {{.Location}}{{with .Weather}} ({{.Description}}){{end}}
+  {{.Description}}
+{{end}}
+To stop receiving these emails visit {{.UnsubscribeURL}}
+`))
+)
+
+// sendReminders emails all the confirmed subscribers about the events
+// taking place tomorrow. It is run daily by cron, see cron.yaml.
+func sendReminders(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+	if !fromCron(w, r) {
+		return
+	}
+
+	// Dates are stored without time zone, so days start at midnight UTC.
+	tomorrow := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	events, err := eventsBetween(ctx, tomorrow, tomorrow.Add(24*time.Hour))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 {
+		platform.Infof(ctx, "no events tomorrow, no reminders to send")
+		return
+	}
+
+	err = mailSubscribers(ctx, func(s *Subscriber) ([]*mailMessage, error) {
+		var msgs []*mailMessage
+		for _, e := range events {
+			var b bytes.Buffer
+			err := reminderTmpl.Execute(&b, map[string]interface{}{
+				"Event":          e,
+				"UnsubscribeURL": absURL(r, "/api/unsubscribe", s.Token),
+			})
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, &mailMessage{
+				Subject: fmt.Sprintf("Reminder: %s is tomorrow", e.Title),
+				Body:    b.String(),
+			})
+		}
+		return msgs, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// sendDigest emails all the confirmed subscribers the list of events taking
+// place in the next seven days. It is run weekly by cron, see cron.yaml.
+func sendDigest(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+	if !fromCron(w, r) {
+		return
+	}
+
+	now := time.Now()
+	events, err := eventsBetween(ctx, now, now.Add(7*24*time.Hour))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 {
+		platform.Infof(ctx, "no events this week, no digest to send")
+		return
+	}
+
+	err = mailSubscribers(ctx, func(s *Subscriber) ([]*mailMessage, error) {
+		var b bytes.Buffer
+		err := digestTmpl.Execute(&b, map[string]interface{}{
+			"Events":         events,
+			"UnsubscribeURL": absURL(r, "/api/unsubscribe", s.Token),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []*mailMessage{{Subject: "Upcoming events this week", Body: b.String()}}, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// fromCron checks the request was sent by App Engine cron, writing an error
+// to the response if it wasn't. App Engine removes this header from
+// external requests.
+func fromCron(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		http.Error(w, "only cron can call this endpoint", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// eventsBetween returns the events in the given time range, with weather.
+func eventsBetween(ctx context.Context, from, to time.Time) ([]Event, error) {
+	var events []Event
+	q := platform.NewQuery(eventKind).
+		Filter("Date >=", from).
+		Filter("Date <", to).
+		Order("Date")
+	if _, err := q.GetAll(ctx, &events); err != nil {
+		return nil, fmt.Errorf("could not fetch events: %v", err)
+	}
+	addWeather(ctx, events)
+	return events, nil
+}
+
+// mailSubscribers sends the emails created by compose to each confirmed
+// subscriber. Failures to send an email are logged and don't stop the rest.
+func mailSubscribers(ctx context.Context, compose func(*Subscriber) ([]*mailMessage, error)) error {
+	sender := newMailSender(ctx)
+	t := platform.NewQuery(subscriberKind).Filter("Confirmed =", true).Run(ctx)
+	sent, failed := 0, 0
+	for {
+		var s Subscriber
+		_, err := t.Next(&s)
+		if err == platform.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not fetch subscribers: %v", err)
+		}
+
+		msgs, err := compose(&s)
+		if err != nil {
+			return fmt.Errorf("could not compose email: %v", err)
+		}
+		for _, msg := range msgs {
+			msg.To = s.Email
+			if err := sender.Send(ctx, msg); err != nil {
+				platform.Errorf(ctx, "sending %q to %s: %v", msg.Subject, s.Email, err)
+				failed++
+				continue
+			}
+			sent++
+		}
+	}
+	platform.Infof(ctx, "sent %d emails, %d failed", sent, failed)
+	return nil
+}
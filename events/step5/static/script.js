@@ -19,6 +19,19 @@ function EventsCtrl($scope, $http) {
   // The fields in the event creation dialog.
   $scope.newEvent = {};
 
+  // Identifies the event being added, so sending it twice creates it once.
+  var newIdempotencyKey = function() {
+    return Date.now().toString(36) + Math.random().toString(36).slice(2);
+  };
+  var idempotencyKey = newIdempotencyKey();
+
+  // Returns the response to the CAPTCHA in the form, if any. The widgets of
+  // the CAPTCHA services set it in a field named after them.
+  var captchaResponse = function() {
+    var field = document.querySelector('form [name$="-response"], form [name="captcha"]');
+    return field ? field.value : '';
+  };
+
   // Display an error using an alert dialog.
   var alertError = function(data, status) {
     alert('code ' + status + ': ' + data);
@@ -32,10 +45,19 @@ function EventsCtrl($scope, $http) {
   };
 
   // Adds a new event throught the API.
-  $scope.addEvent = function() {
-    $http.post('/api/events', $scope.newEvent).
+  // The form can also be sent without JavaScript, so we prevent that.
+  $scope.addEvent = function($event) {
+    $event.preventDefault();
+    var headers = {'Idempotency-Key': idempotencyKey};
+    if (captchaResponse()) {
+      headers['X-Captcha-Response'] = captchaResponse();
+    }
+    $http.post('/api/events', $scope.newEvent, {headers: headers}).
       error(alertError).
-      success(function() {
+      success(function(data, status) {
+        idempotencyKey = newIdempotencyKey();
+        // Events from anonymous users may wait for a moderator to approve them.
+        $scope.submitted = status == 202;
         fetchEvents().then(function () {
           // If everything worked, clear the dialog.
           $scope.event = {};
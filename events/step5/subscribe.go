@@ -0,0 +1,184 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+const subscriberKind = "Subscriber"
+
+// Subscriber is someone who receives reminders and digests by email.
+// Subscribers are stored with their email address as key.
+type Subscriber struct {
+	Email     string
+	Token     string
+	Confirmed bool
+	Created   time.Time
+}
+
+func subscribe(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var data struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	addr, err := mail.ParseAddress(data.Email)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid email: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	key := platform.NewKey(subscriberKind, addr.Address, 0, nil)
+	var s Subscriber
+	if err := platform.Get(ctx, key, &s); err == platform.ErrNoSuchEntity {
+		token, err := newToken()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s = Subscriber{Email: addr.Address, Token: token, Created: time.Now()}
+		if _, err := platform.Put(ctx, key, &s); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// We answer the same way whether the address was already subscribed or
+	// not, so this endpoint can't be used to find who is subscribed.
+	if !s.Confirmed {
+		msg := &mailMessage{
+			To:      s.Email,
+			Subject: "Confirm your subscription to Events",
+			Body: fmt.Sprintf("Hi,\n\nPlease confirm you want to receive reminders and a weekly digest of upcoming events by visiting:\n\n%s\n\nIf you didn't ask for this, just ignore this email.\n",
+				absURL(r, "/api/subscribe/confirm", s.Token)),
+		}
+		if err := newMailSender(ctx).Send(ctx, msg); err != nil {
+			http.Error(w, fmt.Sprintf("could not send confirmation email: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func confirmSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	key, s, err := subscriberByToken(ctx, r.FormValue("token"))
+	if err == platform.ErrNoSuchEntity {
+		http.Error(w, "unknown token", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.Confirmed = true
+	if _, err := platform.Put(ctx, key, s); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "Your subscription is confirmed, thanks!")
+}
+
+func unsubscribe(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	key, _, err := subscriberByToken(ctx, r.FormValue("token"))
+	if err == platform.ErrNoSuchEntity {
+		http.Error(w, "unknown token", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := platform.Delete(ctx, key); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	platform.Infof(ctx, "unsubscribed %s", key.StringID())
+	fmt.Fprintln(w, "You won't receive any more emails from us.")
+}
+
+// subscriberByToken returns the subscriber with the given token, or
+// platform.ErrNoSuchEntity if there's none.
+func subscriberByToken(ctx context.Context, token string) (*platform.Key, *Subscriber, error) {
+	if token == "" {
+		return nil, nil, platform.ErrNoSuchEntity
+	}
+	var subs []Subscriber
+	keys, err := platform.NewQuery(subscriberKind).Filter("Token =", token).Limit(1).GetAll(ctx, &subs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil, platform.ErrNoSuchEntity
+	}
+	return keys[0], &subs[0], nil
+}
+
+// newToken returns a random token, hard to guess.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// absURL returns the absolute URL for the given path in this application,
+// with the given token, if any, as a parameter. Only local servers are
+// reached with plain HTTP.
+func absURL(r *http.Request, path, token string) string {
+	u := url.URL{
+		Scheme: "https",
+		Host:   r.Host,
+		Path:   path,
+	}
+	if token != "" {
+		u.RawQuery = url.Values{"token": {token}}.Encode()
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		u.Scheme = "http"
+	}
+	return u.String()
+}
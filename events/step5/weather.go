@@ -17,14 +17,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
-	"os"
 	"time"
 
 	"golang.org/x/net/context"
 
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/memcache"
-	"google.golang.org/appengine/urlfetch"
+	"github.com/campoy/go-web-workshop/platform"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 const (
@@ -33,22 +33,49 @@ const (
 )
 
 func weather(ctx context.Context, location string) (*Weather, error) {
-	// check if the weather for the location is in memcache.
+	ctx, span := platform.StartSpan(ctx, "weather")
+	defer span.End()
+	span.SetAttributes(attribute.String("weather.location", location))
+
+	// check if the weather for the location is in the cache.
 	var weather Weather
-	_, err := memcache.JSON.Get(ctx, location, &weather)
+	err := platform.CacheGet(ctx, location, &weather)
+	span.SetAttributes(attribute.Bool("weather.cache_hit", err == nil))
 	if err == nil {
+		weatherCache.WithLabelValues("hit").Inc()
 		return &weather, nil
-	} else if err != memcache.ErrCacheMiss {
-		log.Errorf(ctx, "could not retrieve weather for %q from memcache: %v", location, err)
+	} else if err != platform.ErrCacheMiss {
+		platform.Errorf(ctx, "could not retrieve weather for %q from cache: %v", location, err)
+	}
+	weatherCache.WithLabelValues("miss").Inc()
+
+	start := time.Now()
+	w, err := fetchWeather(ctx, location)
+	weatherDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		weatherRequests.WithLabelValues("error").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	weatherRequests.WithLabelValues("ok").Inc()
+
+	// Cache the weather for later.
+	if err := platform.CacheSet(ctx, location, w, 1*time.Hour); err != nil {
+		platform.Errorf(ctx, "could not cache weather for %q: %v", location, err)
 	}
 
+	return w, nil
+}
+
+// fetchWeather fetches the weather for the location from the weather API.
+func fetchWeather(ctx context.Context, location string) (*Weather, error) {
 	// Prepare the request to the weather API.
 	values := make(url.Values)
-	values.Set("APPID", os.Getenv("WEATHER_API_KEY"))
+	values.Set("APPID", cfg.WeatherAPIKey)
 	values.Set("q", location)
 	url := apiURL + "?" + values.Encode()
 
-	res, err := urlfetch.Client(ctx).Get(url)
+	res, err := platform.Client(ctx).Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("could not get weather: %v", err)
 	}
@@ -76,19 +103,8 @@ func weather(ctx context.Context, location string) (*Weather, error) {
 	}
 
 	// We just take the first value for the weather.
-	weather = data.Weather[0]
+	weather := data.Weather[0]
 	// And make the icon a complete url.
 	weather.Icon = fmt.Sprintf(iconURLTemplate, weather.Icon)
-
-	// Cache the weather in memcache for later.
-	item := &memcache.Item{
-		Key:        location,
-		Object:     &weather,
-		Expiration: 1 * time.Hour,
-	}
-	if err := memcache.JSON.Set(ctx, item); err != nil {
-		log.Errorf(ctx, "could not cache weather for %q: %v", location, err)
-	}
-
 	return &weather, nil
 }
@@ -0,0 +1,337 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	webhookKind  = "Webhook"
+	deliveryKind = "Delivery"
+
+	// webhookQueue is the task queue used to deliver webhooks, see queue.yaml.
+	webhookQueue = "webhooks"
+)
+
+// Types of changes webhooks can be notified about.
+const (
+	eventCreated  = "event.created"
+	eventUpdated  = "event.updated"
+	eventDeleted  = "event.deleted"
+	eventRestored = "event.restored"
+)
+
+var webhookEventTypes = []string{eventCreated, eventUpdated, eventDeleted, eventRestored}
+
+// Webhook is a URL that is notified when events change.
+type Webhook struct {
+	ID      int64     `json:"id" datastore:"-"`
+	URL     string    `json:"url"`
+	Secret  string    `json:"secret,omitempty" datastore:",noindex"`
+	Events  []string  `json:"events"`
+	Created time.Time `json:"created"`
+}
+
+// Delivery is an attempt to deliver a notification to a webhook.
+// Deliveries are stored as children of their webhook.
+type Delivery struct {
+	Type       string        `json:"type"`
+	EventID    int64         `json:"event_id"`
+	Attempt    int           `json:"attempt"`
+	Time       time.Time     `json:"time"`
+	StatusCode int           `json:"status_code"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// webhookPayload is the body sent to webhooks.
+type webhookPayload struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Event *Event    `json:"event"`
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	hooks := []Webhook{}
+	keys, err := platform.NewQuery(webhookKind).GetAll(ctx, &hooks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Secrets are only shown when a webhook is registered.
+	for i, key := range keys {
+		hooks[i].ID = key.IntID()
+		hooks[i].Secret = ""
+	}
+	writeJSON(ctx, w, http.StatusOK, hooks)
+}
+
+func addWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var hook Webhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "url must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+	if !publicHost(u.Hostname()) {
+		http.Error(w, "url must be on a public address", http.StatusBadRequest)
+		return
+	}
+	if len(hook.Events) == 0 {
+		hook.Events = webhookEventTypes
+	}
+	for _, typ := range hook.Events {
+		if !validEventType(typ) {
+			http.Error(w, fmt.Sprintf("unknown event type %q", typ), http.StatusBadRequest)
+			return
+		}
+	}
+	if hook.Secret == "" {
+		secret, err := newToken()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hook.Secret = secret
+	}
+	hook.Created = time.Now()
+
+	key, err := platform.Put(ctx, platform.NewIncompleteKey(webhookKind, nil), &hook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hook.ID = key.IntID()
+	writeJSON(ctx, w, http.StatusCreated, hook)
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	key, _, err := webhookByID(ctx, mux.Vars(r)["id"])
+	if err == platform.ErrNoSuchEntity {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Delete the webhook together with its delivery log.
+	keys, err := platform.NewQuery(deliveryKind).Ancestor(key).KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := platform.DeleteMulti(ctx, append(keys, key)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+
+	key, _, err := webhookByID(ctx, mux.Vars(r)["id"])
+	if err == platform.ErrNoSuchEntity {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deliveries := []Delivery{}
+	q := platform.NewQuery(deliveryKind).Ancestor(key).Order("-Time").Limit(100)
+	if _, err := q.GetAll(ctx, &deliveries); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(ctx, w, http.StatusOK, deliveries)
+}
+
+// notifyWebhooks enqueues a task to notify each webhook interested in the
+// given type of change. Errors are logged, since the change already happened.
+func notifyWebhooks(ctx context.Context, typ string, e *Event) {
+	payload, err := json.Marshal(webhookPayload{Type: typ, Time: time.Now(), Event: e})
+	if err != nil {
+		platform.Errorf(ctx, "encoding webhook payload: %v", err)
+		return
+	}
+
+	keys, err := platform.NewQuery(webhookKind).Filter("Events =", typ).KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		platform.Errorf(ctx, "fetching webhooks for %s: %v", typ, err)
+		return
+	}
+	for _, key := range keys {
+		params := url.Values{
+			"webhook": {strconv.FormatInt(key.IntID(), 10)},
+			"type":    {typ},
+			"event":   {strconv.FormatInt(e.ID, 10)},
+			"payload": {string(payload)},
+		}
+		if err := platform.AddTask(ctx, webhookQueue, "/api/tasks/deliver", params); err != nil {
+			platform.Errorf(ctx, "enqueuing %s for webhook %d: %v", typ, key.IntID(), err)
+		}
+	}
+}
+
+// deliverWebhook sends a signed payload to a webhook and records the result.
+// It's called by the task queue, which retries with backoff when the
+// handler fails, as configured in queue.yaml.
+func deliverWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := platform.NewContext(r)
+	// Anyone can send the X-AppEngine-QueueName header, so it only tells
+	// tasks apart from administrators calling the endpoint by mistake: App
+	// Engine removes it from external requests, and /api/tasks/ is restricted
+	// to administrators by app.yaml or, outside App Engine, the admin token.
+	if r.Header.Get("X-AppEngine-QueueName") == "" {
+		http.Error(w, "only the task queue can call this endpoint", http.StatusForbidden)
+		return
+	}
+
+	key, hook, err := webhookByID(ctx, r.FormValue("webhook"))
+	if err == platform.ErrNoSuchEntity {
+		// The webhook was deleted, there's nothing to retry.
+		platform.Infof(ctx, "dropping delivery to deleted webhook %s", r.FormValue("webhook"))
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	payload := []byte(r.FormValue("payload"))
+	d := Delivery{Type: r.FormValue("type"), Time: time.Now()}
+	d.EventID, _ = strconv.ParseInt(r.FormValue("event"), 10, 64)
+	retries, _ := strconv.Atoi(r.Header.Get("X-AppEngine-TaskRetryCount"))
+	d.Attempt = retries + 1
+
+	d.StatusCode, err = post(ctx, hook, d.Type, payload)
+	d.Duration = time.Since(d.Time)
+	if err == nil && (d.StatusCode < 200 || d.StatusCode >= 300) {
+		err = fmt.Errorf("webhook answered with status %d", d.StatusCode)
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	if _, perr := platform.Put(ctx, platform.NewIncompleteKey(deliveryKind, key), &d); perr != nil {
+		platform.Errorf(ctx, "recording delivery to webhook %d: %v", key.IntID(), perr)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// post sends the payload to the webhook, signed with its secret, and
+// returns the status code of the response.
+func post(ctx context.Context, hook *Webhook, typ string, payload []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Events-Type", typ)
+	req.Header.Set("X-Events-Signature", "sha256="+sign(hook.Secret, payload))
+
+	// The URL is given by an administrator, but it could still lead to
+	// the private network of the application, directly or by redirection.
+	res, err := platform.PublicClient(ctx).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	return res.StatusCode, nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the payload. Receivers can
+// compute it with the webhook secret to check the payload comes from us.
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookByID fetches the webhook with the given id, returning
+// platform.ErrNoSuchEntity if the id is not valid or there's no such webhook.
+func webhookByID(ctx context.Context, id string) (*platform.Key, *Webhook, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n <= 0 {
+		return nil, nil, platform.ErrNoSuchEntity
+	}
+	key := platform.NewKey(webhookKind, "", n, nil)
+	var hook Webhook
+	if err := platform.Get(ctx, key, &hook); err != nil {
+		return nil, nil, err
+	}
+	hook.ID = n
+	return key, &hook, nil
+}
+
+// publicHost returns whether host can be on a public address. Host names
+// are only resolved when delivering, since their addresses can change, but
+// literal addresses and localhost are refused early.
+func publicHost(host string) bool {
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return platform.PublicIP(ip)
+	}
+	return true
+}
+
+func validEventType(typ string) bool {
+	for _, t := range webhookEventTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
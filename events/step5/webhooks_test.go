@@ -0,0 +1,42 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAddWebhookURL(t *testing.T) {
+	setup(t)
+	for _, tt := range []struct {
+		url    string
+		status int
+	}{
+		{"https://example.com/hook", http.StatusCreated},
+		{"http://93.184.215.14:8080/hook", http.StatusCreated},
+		{"ftp://example.com/hook", http.StatusBadRequest},
+		{"/hook", http.StatusBadRequest},
+		{"http://localhost:8080/hook", http.StatusBadRequest},
+		{"http://127.0.0.1/hook", http.StatusBadRequest},
+		{"http://10.0.0.1/hook", http.StatusBadRequest},
+		{"http://169.254.169.254/computeMetadata/v1/", http.StatusBadRequest},
+		{"http://[::1]/hook", http.StatusBadRequest},
+		{"http://[fd00:ec2::254]/hook", http.StatusBadRequest},
+	} {
+		if w := serve("POST", "/api/webhooks", `{"url": "`+tt.url+`"}`); w.Code != tt.status {
+			t.Errorf("adding %s: got status %d, want %d: %s", tt.url, w.Code, tt.status, w.Body)
+		}
+	}
+}
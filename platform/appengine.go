@@ -0,0 +1,272 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build appengine
+// +build appengine
+
+package platform
+
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+	"google.golang.org/appengine/user"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// defaultBackend returns the backend using the App Engine APIs.
+func defaultBackend() *Backend {
+	return &Backend{
+		NewContext: appengine.NewContext,
+		Client:     urlfetch.Client,
+		// URL Fetch goes through Google's servers, which can't reach the
+		// private network of the application.
+		PublicClient: urlfetch.Client,
+		CurrentUser: func(ctx context.Context) string {
+			if u := user.Current(ctx); u != nil {
+				return u.Email
+			}
+			return ""
+		},
+		IsAdmin: user.IsAdmin,
+		Log:     appengineLogger{},
+		Store:   appengineStore{},
+		Cache:   appengineCache{},
+		Queue:   appengineQueue{},
+	}
+}
+
+// appengineLogger writes log entries as JSON objects with the App Engine
+// log API, which groups them by request.
+type appengineLogger struct{}
+
+func (appengineLogger) Log(ctx context.Context, e *Entry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf(ctx, "could not encode log entry %q: %v", e.Message, err)
+		return
+	}
+	switch e.Severity {
+	case Error:
+		log.Errorf(ctx, "%s", b)
+	case Warning:
+		log.Warningf(ctx, "%s", b)
+	default:
+		log.Infof(ctx, "%s", b)
+	}
+}
+
+// appengineStore uses the App Engine datastore.
+type appengineStore struct{}
+
+func (appengineStore) Get(ctx context.Context, key *Key, dst interface{}) error {
+	return fromAppEngineErr(datastore.Get(ctx, toAppEngineKey(ctx, key), dst))
+}
+
+func (appengineStore) Put(ctx context.Context, key *Key, src interface{}) (*Key, error) {
+	k, err := datastore.Put(ctx, toAppEngineKey(ctx, key), src)
+	if err != nil {
+		return nil, err
+	}
+	return fromAppEngineKey(k), nil
+}
+
+func (appengineStore) PutMulti(ctx context.Context, keys []*Key, src interface{}) ([]*Key, error) {
+	ks := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		ks[i] = toAppEngineKey(ctx, key)
+	}
+	ks, err := datastore.PutMulti(ctx, ks, src)
+	if err != nil {
+		return nil, err
+	}
+	return fromAppEngineKeys(ks), nil
+}
+
+func (appengineStore) Delete(ctx context.Context, key *Key) error {
+	return datastore.Delete(ctx, toAppEngineKey(ctx, key))
+}
+
+func (appengineStore) DeleteMulti(ctx context.Context, keys []*Key) error {
+	ks := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		ks[i] = toAppEngineKey(ctx, key)
+	}
+	return datastore.DeleteMulti(ctx, ks)
+}
+
+func (appengineStore) GetAll(ctx context.Context, q *Query, dst interface{}) ([]*Key, error) {
+	aq, err := toAppEngineQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := aq.GetAll(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	return fromAppEngineKeys(ks), nil
+}
+
+func (appengineStore) Run(ctx context.Context, q *Query) Iterator {
+	aq, err := toAppEngineQuery(ctx, q)
+	if err != nil {
+		return errIterator{err}
+	}
+	return appengineIterator{aq.Run(ctx)}
+}
+
+func (appengineStore) RunInTransaction(ctx context.Context, f func(ctx context.Context) error, crossGroup bool) error {
+	return datastore.RunInTransaction(ctx, f, &datastore.TransactionOptions{XG: crossGroup})
+}
+
+type appengineIterator struct{ t *datastore.Iterator }
+
+func (it appengineIterator) Next(dst interface{}) (*Key, error) {
+	k, err := it.t.Next(dst)
+	if err == datastore.Done {
+		return nil, Done
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromAppEngineKey(k), nil
+}
+
+func (it appengineIterator) Cursor() (string, error) {
+	c, err := it.t.Cursor()
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
+func toAppEngineQuery(ctx context.Context, q *Query) (*datastore.Query, error) {
+	aq := datastore.NewQuery(q.kind)
+	if q.ancestor != nil {
+		aq = aq.Ancestor(toAppEngineKey(ctx, q.ancestor))
+	}
+	for _, f := range q.filters {
+		aq = aq.Filter(f.field+" "+f.op, f.value)
+	}
+	for _, o := range q.orders {
+		aq = aq.Order(o)
+	}
+	if q.limit >= 0 {
+		aq = aq.Limit(q.limit)
+	}
+	if q.keysOnly {
+		aq = aq.KeysOnly()
+	}
+	if q.start != "" {
+		c, err := datastore.DecodeCursor(q.start)
+		if err != nil {
+			return nil, err
+		}
+		aq = aq.Start(c)
+	}
+	return aq, nil
+}
+
+func toAppEngineKey(ctx context.Context, k *Key) *datastore.Key {
+	if k == nil {
+		return nil
+	}
+	return datastore.NewKey(ctx, k.kind, k.name, k.id, toAppEngineKey(ctx, k.parent))
+}
+
+func fromAppEngineKey(k *datastore.Key) *Key {
+	if k == nil {
+		return nil
+	}
+	return NewKey(k.Kind(), k.StringID(), k.IntID(), fromAppEngineKey(k.Parent()))
+}
+
+func fromAppEngineKeys(ks []*datastore.Key) []*Key {
+	keys := make([]*Key, len(ks))
+	for i, k := range ks {
+		keys[i] = fromAppEngineKey(k)
+	}
+	return keys
+}
+
+func fromAppEngineErr(err error) error {
+	if err == datastore.ErrNoSuchEntity {
+		return ErrNoSuchEntity
+	}
+	return err
+}
+
+// appengineCache uses App Engine memcache.
+type appengineCache struct{}
+
+func (appengineCache) Get(ctx context.Context, key string, v interface{}) error {
+	_, err := memcache.JSON.Get(ctx, key, v)
+	if err == memcache.ErrCacheMiss {
+		return ErrCacheMiss
+	}
+	return err
+}
+
+func (appengineCache) Set(ctx context.Context, key string, v interface{}, expiration time.Duration) error {
+	return memcache.JSON.Set(ctx, &memcache.Item{Key: key, Object: v, Expiration: expiration})
+}
+
+// Update uses compare and swap, or add if there's no value, so it fails if
+// another instance changed the value meanwhile.
+func (appengineCache) Update(ctx context.Context, key string, v interface{}, expiration time.Duration, f func(found bool) error) error {
+	for i := 0; i < updateAttempts; i++ {
+		item, err := memcache.JSON.Get(ctx, key, v)
+		if err != nil && err != memcache.ErrCacheMiss {
+			return err
+		}
+		found := err == nil
+		if err := f(found); err != nil {
+			return err
+		}
+
+		if found {
+			item.Object, item.Expiration = v, expiration
+			err = memcache.JSON.CompareAndSwap(ctx, item)
+		} else {
+			err = memcache.JSON.Add(ctx, &memcache.Item{Key: key, Object: v, Expiration: expiration})
+		}
+		if err != memcache.ErrCASConflict && err != memcache.ErrNotStored {
+			return err
+		}
+	}
+	return ErrCacheConflict
+}
+
+// appengineQueue uses App Engine push queues.
+type appengineQueue struct{}
+
+func (appengineQueue) Add(ctx context.Context, queue, path string, params url.Values) error {
+	t := taskqueue.NewPOSTTask(path, params)
+	if id := RequestID(ctx); id != "" {
+		t.Header.Set(RequestIDHeader, id)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(t.Header))
+	_, err := taskqueue.Add(ctx, t, queue)
+	return err
+}
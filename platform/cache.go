@@ -0,0 +1,168 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrCacheMiss is returned when a key is not in the cache.
+var ErrCacheMiss = errors.New("platform: cache miss")
+
+// ErrCacheConflict is returned by Update when the value kept changing while
+// it was updated.
+var ErrCacheConflict = errors.New("platform: cache conflict")
+
+const (
+	// updateAttempts is the number of times Update tries to change a value.
+	updateAttempts = 5
+	// sweepInterval is how often memoryCache deletes the expired values.
+	sweepInterval = time.Minute
+)
+
+// A Cache stores values encoded as JSON for a limited time, as the JSON codec
+// of App Engine memcache does.
+type Cache interface {
+	// Get decodes the value for key into v, or returns ErrCacheMiss.
+	Get(ctx context.Context, key string, v interface{}) error
+	// Set stores v for key. Values don't expire if expiration is zero.
+	Set(ctx context.Context, key string, v interface{}, expiration time.Duration) error
+	// Update atomically changes the value for key: it decodes the current
+	// value into v, calls f with whether there was one, and stores v unless f
+	// returns an error, which Update returns. If the value changed meanwhile
+	// it starts again, up to a few times before returning ErrCacheConflict.
+	Update(ctx context.Context, key string, v interface{}, expiration time.Duration, f func(found bool) error) error
+}
+
+// memoryCache is a Cache local to the process. Expired values are deleted
+// when read, and every sweepInterval when values are stored, so values that
+// are never read again don't use memory forever.
+type memoryCache struct {
+	mu        sync.Mutex
+	items     map[string]cacheItem
+	lastSweep time.Time
+}
+
+type cacheItem struct {
+	value   []byte
+	expires time.Time
+}
+
+func (i cacheItem) expired(now time.Time) bool {
+	return !i.expires.IsZero() && now.After(i.expires)
+}
+
+// NewMemoryCache returns a Cache local to the process, which is lost when
+// the process exits.
+func NewMemoryCache() Cache {
+	return &memoryCache{items: make(map[string]cacheItem)}
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string, v interface{}) error {
+	c.mu.Lock()
+	item, ok := c.items[key]
+	if ok && item.expired(time.Now()) {
+		delete(c.items, key)
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return ErrCacheMiss
+	}
+	return json.Unmarshal(item.value, v)
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, v interface{}, expiration time.Duration) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	item := cacheItem{value: b}
+	if expiration > 0 {
+		item.expires = time.Now().Add(expiration)
+	}
+
+	c.mu.Lock()
+	c.items[key] = item
+	c.sweep()
+	c.mu.Unlock()
+	return nil
+}
+
+// sweep deletes the expired values, unless it did less than sweepInterval
+// ago. It must be called with c.mu held.
+func (c *memoryCache) sweep() {
+	now := time.Now()
+	if now.Sub(c.lastSweep) < sweepInterval {
+		return
+	}
+	c.lastSweep = now
+	for key, item := range c.items {
+		if item.expired(now) {
+			delete(c.items, key)
+		}
+	}
+}
+
+// Update holds the lock while f runs, so values never change meanwhile.
+func (c *memoryCache) Update(ctx context.Context, key string, v interface{}, expiration time.Duration, f func(found bool) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, found := c.items[key]
+	if found && item.expired(time.Now()) {
+		found = false
+	}
+	if found {
+		if err := json.Unmarshal(item.value, v); err != nil {
+			return err
+		}
+	}
+	if err := f(found); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	item = cacheItem{value: b}
+	if expiration > 0 {
+		item.expires = time.Now().Add(expiration)
+	}
+	c.items[key] = item
+	c.sweep()
+	return nil
+}
+
+// CacheGet decodes the cached value for key into v, or returns ErrCacheMiss.
+func CacheGet(ctx context.Context, key string, v interface{}) error {
+	return Current.Cache.Get(ctx, key, v)
+}
+
+// CacheSet caches v for key, see Cache.
+func CacheSet(ctx context.Context, key string, v interface{}, expiration time.Duration) error {
+	return Current.Cache.Set(ctx, key, v, expiration)
+}
+
+// CacheUpdate atomically changes the cached value for key, see Cache.
+func CacheUpdate(ctx context.Context, key string, v interface{}, expiration time.Duration, f func(found bool) error) error {
+	return Current.Cache.Update(ctx, key, v, expiration, f)
+}
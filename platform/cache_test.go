@@ -0,0 +1,50 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestMemoryCacheSweep(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache().(*memoryCache)
+	for i := 0; i < 10; i++ {
+		if err := c.Set(ctx, fmt.Sprint("expired", i), i, time.Nanosecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Set(ctx, "kept", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	// Values that are never read are only deleted by the next sweep.
+	c.lastSweep = time.Now().Add(-sweepInterval)
+	var n int
+	err := c.Update(ctx, "counter", &n, time.Minute, func(found bool) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.items) != 2 {
+		t.Errorf("got %d cached values, want 2", len(c.items))
+	}
+}
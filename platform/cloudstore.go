@@ -0,0 +1,262 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+// +build !appengine
+
+package platform
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/datastore"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// cloudStore uses Cloud Datastore, the same database used by App Engine.
+// The project is read from GOOGLE_CLOUD_PROJECT, and the client connects
+// to the emulator if DATASTORE_EMULATOR_HOST is set.
+type cloudStore struct {
+	once   sync.Once
+	client *datastore.Client
+	err    error
+}
+
+// txKey is the context key for the transaction in RunInTransaction.
+type txKey struct{}
+
+func (s *cloudStore) connect(ctx context.Context) (*datastore.Client, error) {
+	s.once.Do(func() {
+		project := os.Getenv("GOOGLE_CLOUD_PROJECT")
+		if project == "" {
+			project = os.Getenv("DATASTORE_PROJECT_ID")
+		}
+		s.client, s.err = datastore.NewClient(context.Background(), project)
+		if s.err != nil {
+			s.err = fmt.Errorf("could not connect to Cloud Datastore: %v", s.err)
+		}
+	})
+	return s.client, s.err
+}
+
+func (s *cloudStore) Get(ctx context.Context, key *Key, dst interface{}) error {
+	c, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	if tx, ok := ctx.Value(txKey{}).(*datastore.Transaction); ok {
+		err = tx.Get(toCloudKey(key), dst)
+	} else {
+		err = c.Get(ctx, toCloudKey(key), dst)
+	}
+	if err == datastore.ErrNoSuchEntity {
+		return ErrNoSuchEntity
+	}
+	return err
+}
+
+func (s *cloudStore) Put(ctx context.Context, key *Key, src interface{}) (*Key, error) {
+	keys, err := s.PutMulti(ctx, []*Key{key}, []interface{}{src})
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+func (s *cloudStore) PutMulti(ctx context.Context, keys []*Key, src interface{}) ([]*Key, error) {
+	c, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ks := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		ks[i] = toCloudKey(key)
+	}
+
+	tx, ok := ctx.Value(txKey{}).(*datastore.Transaction)
+	if !ok {
+		ks, err = c.PutMulti(ctx, ks, src)
+		if err != nil {
+			return nil, err
+		}
+		return fromCloudKeys(ks), nil
+	}
+
+	// Keys put in a transaction are only complete after committing, so we
+	// allocate the ids beforehand to return complete keys as App Engine does.
+	var incomplete []int
+	for i, k := range ks {
+		if k.Incomplete() {
+			incomplete = append(incomplete, i)
+		}
+	}
+	if len(incomplete) > 0 {
+		toAllocate := make([]*datastore.Key, len(incomplete))
+		for i, j := range incomplete {
+			toAllocate[i] = ks[j]
+		}
+		allocated, err := c.AllocateIDs(ctx, toAllocate)
+		if err != nil {
+			return nil, err
+		}
+		for i, j := range incomplete {
+			ks[j] = allocated[i]
+		}
+	}
+	if _, err := tx.PutMulti(ks, src); err != nil {
+		return nil, err
+	}
+	return fromCloudKeys(ks), nil
+}
+
+func (s *cloudStore) Delete(ctx context.Context, key *Key) error {
+	return s.DeleteMulti(ctx, []*Key{key})
+}
+
+func (s *cloudStore) DeleteMulti(ctx context.Context, keys []*Key) error {
+	c, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	ks := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		ks[i] = toCloudKey(key)
+	}
+	if tx, ok := ctx.Value(txKey{}).(*datastore.Transaction); ok {
+		return tx.DeleteMulti(ks)
+	}
+	return c.DeleteMulti(ctx, ks)
+}
+
+func (s *cloudStore) GetAll(ctx context.Context, q *Query, dst interface{}) ([]*Key, error) {
+	c, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cq, err := toCloudQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := c.GetAll(ctx, cq, dst)
+	if err != nil {
+		return nil, err
+	}
+	return fromCloudKeys(ks), nil
+}
+
+func (s *cloudStore) Run(ctx context.Context, q *Query) Iterator {
+	c, err := s.connect(ctx)
+	if err != nil {
+		return errIterator{err}
+	}
+	cq, err := toCloudQuery(ctx, q)
+	if err != nil {
+		return errIterator{err}
+	}
+	return cloudIterator{c.Run(ctx, cq)}
+}
+
+func (s *cloudStore) RunInTransaction(ctx context.Context, f func(ctx context.Context) error, crossGroup bool) error {
+	c, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	// Cloud Datastore transactions can always span several entity groups.
+	_, err = c.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		return f(context.WithValue(ctx, txKey{}, tx))
+	})
+	return err
+}
+
+type cloudIterator struct{ t *datastore.Iterator }
+
+func (it cloudIterator) Next(dst interface{}) (*Key, error) {
+	k, err := it.t.Next(dst)
+	if err == iterator.Done {
+		return nil, Done
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromCloudKey(k), nil
+}
+
+func (it cloudIterator) Cursor() (string, error) {
+	c, err := it.t.Cursor()
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
+func toCloudQuery(ctx context.Context, q *Query) (*datastore.Query, error) {
+	cq := datastore.NewQuery(q.kind)
+	if q.ancestor != nil {
+		cq = cq.Ancestor(toCloudKey(q.ancestor))
+	}
+	for _, f := range q.filters {
+		cq = cq.FilterField(f.field, f.op, f.value)
+	}
+	for _, o := range q.orders {
+		cq = cq.Order(o)
+	}
+	if q.limit >= 0 {
+		cq = cq.Limit(q.limit)
+	}
+	if q.keysOnly {
+		cq = cq.KeysOnly()
+	}
+	if q.start != "" {
+		c, err := datastore.DecodeCursor(q.start)
+		if err != nil {
+			return nil, err
+		}
+		cq = cq.Start(c)
+	}
+	if tx, ok := ctx.Value(txKey{}).(*datastore.Transaction); ok {
+		cq = cq.Transaction(tx)
+	}
+	return cq, nil
+}
+
+func toCloudKey(k *Key) *datastore.Key {
+	if k == nil {
+		return nil
+	}
+	parent := toCloudKey(k.parent)
+	if k.name != "" {
+		return datastore.NameKey(k.kind, k.name, parent)
+	}
+	if k.id != 0 {
+		return datastore.IDKey(k.kind, k.id, parent)
+	}
+	return datastore.IncompleteKey(k.kind, parent)
+}
+
+func fromCloudKey(k *datastore.Key) *Key {
+	if k == nil {
+		return nil
+	}
+	return NewKey(k.Kind, k.Name, k.ID, fromCloudKey(k.Parent))
+}
+
+func fromCloudKeys(ks []*datastore.Key) []*Key {
+	keys := make([]*Key, len(ks))
+	for i, k := range ks {
+		keys[i] = fromCloudKey(k)
+	}
+	return keys
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader is the header identifying a request, so the log entries
+// related to it can be found across services.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID matches the request ids accepted from clients, which are
+// written to logs and responses.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+// ValidRequestID returns whether id can identify a request.
+func ValidRequestID(id string) bool { return validRequestID.MatchString(id) }
+
+// Severities of log entries, as named by Stackdriver Logging.
+const (
+	Info    = "INFO"
+	Warning = "WARNING"
+	Error   = "ERROR"
+)
+
+// A Logger writes log entries.
+type Logger interface {
+	Log(ctx context.Context, e *Entry)
+}
+
+// Entry is a structured log entry, encoded as a JSON object with the fields
+// time, severity, message, request_id, trace_id and the ones in Fields.
+type Entry struct {
+	Time      time.Time
+	Severity  string
+	Message   string
+	RequestID string
+	TraceID   string
+	Fields    map[string]interface{}
+}
+
+// MarshalJSON encodes the entry as a flat JSON object.
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(e.Fields)+4)
+	for k, v := range e.Fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		m[k] = v
+	}
+	m["time"] = e.Time.Format(time.RFC3339Nano)
+	m["severity"] = e.Severity
+	m["message"] = e.Message
+	if e.RequestID != "" {
+		m["request_id"] = e.RequestID
+	}
+	if e.TraceID != "" {
+		m["trace_id"] = e.TraceID
+	}
+	return json.Marshal(m)
+}
+
+// Log writes a log entry with the given fields, and the request id and
+// trace id in ctx.
+func Log(ctx context.Context, severity, msg string, fields map[string]interface{}) {
+	e := &Entry{
+		Time:      time.Now(),
+		Severity:  severity,
+		Message:   msg,
+		RequestID: RequestID(ctx),
+		Fields:    fields,
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		e.TraceID = sc.TraceID().String()
+	}
+	Current.Log.Log(ctx, e)
+}
+
+// Infof logs an informational message.
+func Infof(ctx context.Context, format string, args ...interface{}) {
+	Log(ctx, Info, fmt.Sprintf(format, args...), nil)
+}
+
+// Warningf logs a warning.
+func Warningf(ctx context.Context, format string, args ...interface{}) {
+	Log(ctx, Warning, fmt.Sprintf(format, args...), nil)
+}
+
+// Errorf logs an error.
+func Errorf(ctx context.Context, format string, args ...interface{}) {
+	Log(ctx, Error, fmt.Sprintf(format, args...), nil)
+}
+
+// requestIDKey is the context key for the request id.
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the given request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id in ctx, or "" if there's none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a new random request id.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDTransport adds the X-Request-ID header to outgoing requests.
+type requestIDTransport struct {
+	id   string
+	base http.RoundTripper
+}
+
+func (t requestIDTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// RoundTrippers must not modify the request, so we send a copy.
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = make(http.Header, len(r.Header)+1)
+	for k, v := range r.Header {
+		r2.Header[k] = v
+	}
+	r2.Header.Set(RequestIDHeader, t.id)
+	return base.RoundTrip(r2)
+}
@@ -0,0 +1,466 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// memoryStore is a Store keeping entities in memory.
+//
+// Entities are saved following their datastore struct tags, so fields
+// tagged with "-" are not stored. Filters on slices match if any of their
+// elements does, as in the datastore. Results are ordered by key after
+// the orders in the query, and cursors are offsets in the results.
+type memoryStore struct {
+	// tx serializes transactions, whose changes are rolled back on errors.
+	tx sync.Mutex
+
+	mu       sync.Mutex
+	entities map[string]*entity
+	nextID   int64
+}
+
+type entity struct {
+	key   *Key
+	props map[string]reflect.Value
+}
+
+// memoryTx keeps the entities changed by a transaction as they were before
+// it, nil for the ones that didn't exist.
+type memoryTx struct {
+	store *memoryStore
+	saved map[string]*entity
+}
+
+type memoryTxKey struct{}
+
+// change records that the entity with the given key is about to change, if
+// it's changed by a transaction of s. It must be called with s.mu held.
+func (s *memoryStore) change(ctx context.Context, key string) {
+	tx, ok := ctx.Value(memoryTxKey{}).(*memoryTx)
+	if !ok || tx.store != s {
+		return
+	}
+	if _, saved := tx.saved[key]; !saved {
+		tx.saved[key] = s.entities[key]
+	}
+}
+
+// NewMemoryStore returns an empty Store keeping entities in memory, which
+// are lost when the process exits. Transactions run one at a time.
+func NewMemoryStore() Store {
+	return &memoryStore{entities: make(map[string]*entity)}
+}
+
+func (s *memoryStore) Get(ctx context.Context, key *Key, dst interface{}) error {
+	if key == nil || key.Incomplete() {
+		return fmt.Errorf("platform: invalid key %v", key)
+	}
+	s.mu.Lock()
+	e, ok := s.entities[key.String()]
+	s.mu.Unlock()
+	if !ok {
+		return ErrNoSuchEntity
+	}
+	return load(dst, e.props)
+}
+
+func (s *memoryStore) Put(ctx context.Context, key *Key, src interface{}) (*Key, error) {
+	props, err := save(src)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key.Incomplete() {
+		s.nextID++
+		key = NewKey(key.Kind(), "", s.nextID, key.Parent())
+	}
+	s.change(ctx, key.String())
+	s.entities[key.String()] = &entity{key: key, props: props}
+	return key, nil
+}
+
+func (s *memoryStore) PutMulti(ctx context.Context, keys []*Key, src interface{}) ([]*Key, error) {
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Slice || v.Len() != len(keys) {
+		return nil, fmt.Errorf("platform: src must be a slice with one value per key")
+	}
+	res := make([]*Key, len(keys))
+	for i, key := range keys {
+		k, err := s.Put(ctx, key, v.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		res[i] = k
+	}
+	return res, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key *Key) error {
+	s.mu.Lock()
+	s.change(ctx, key.String())
+	delete(s.entities, key.String())
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) DeleteMulti(ctx context.Context, keys []*Key) error {
+	for _, key := range keys {
+		if err := s.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) GetAll(ctx context.Context, q *Query, dst interface{}) ([]*Key, error) {
+	var slice reflect.Value
+	if dst != nil {
+		slice = reflect.ValueOf(dst)
+		if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+			return nil, fmt.Errorf("platform: dst must be a pointer to a slice, got %T", dst)
+		}
+		slice = slice.Elem()
+	}
+
+	var keys []*Key
+	it := s.Run(ctx, q)
+	for {
+		var elem reflect.Value
+		var dst interface{}
+		if slice.IsValid() {
+			elem = reflect.New(slice.Type().Elem())
+			dst = elem.Interface()
+		}
+		key, err := it.Next(dst)
+		if err == Done {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		if slice.IsValid() {
+			slice.Set(reflect.Append(slice, elem.Elem()))
+		}
+	}
+}
+
+func (s *memoryStore) Run(ctx context.Context, q *Query) Iterator {
+	start := 0
+	if q.start != "" {
+		n, err := strconv.Atoi(q.start)
+		if err != nil {
+			return errIterator{fmt.Errorf("platform: invalid cursor %q", q.start)}
+		}
+		start = n
+	}
+
+	s.mu.Lock()
+	var results []*entity
+	for _, e := range s.entities {
+		if q.matches(e) {
+			results = append(results, e)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(results, func(i, j int) bool { return q.less(results[i], results[j]) })
+	if start > len(results) {
+		start = len(results)
+	}
+	results = results[start:]
+	if q.limit >= 0 && q.limit < len(results) {
+		results = results[:q.limit]
+	}
+	return &memoryIterator{results: results, offset: start, keysOnly: q.keysOnly}
+}
+
+// RunInTransaction runs f, restoring the entities it changed if it fails,
+// while the changes made outside the transaction meanwhile are kept.
+// Transactions run one at a time.
+func (s *memoryStore) RunInTransaction(ctx context.Context, f func(ctx context.Context) error, crossGroup bool) error {
+	s.tx.Lock()
+	defer s.tx.Unlock()
+
+	tx := &memoryTx{store: s, saved: make(map[string]*entity)}
+	if err := f(context.WithValue(ctx, memoryTxKey{}, tx)); err != nil {
+		s.mu.Lock()
+		for k, e := range tx.saved {
+			if e == nil {
+				delete(s.entities, k)
+			} else {
+				s.entities[k] = e
+			}
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+type memoryIterator struct {
+	results  []*entity
+	offset   int
+	keysOnly bool
+}
+
+func (it *memoryIterator) Next(dst interface{}) (*Key, error) {
+	if len(it.results) == 0 {
+		return nil, Done
+	}
+	e := it.results[0]
+	it.results = it.results[1:]
+	it.offset++
+	if dst != nil && !it.keysOnly {
+		if err := load(dst, e.props); err != nil {
+			return nil, err
+		}
+	}
+	return e.key, nil
+}
+
+func (it *memoryIterator) Cursor() (string, error) { return strconv.Itoa(it.offset), nil }
+
+// matches returns whether the entity is a result of the query.
+func (q *Query) matches(e *entity) bool {
+	if e.key.Kind() != q.kind {
+		return false
+	}
+	if q.ancestor != nil {
+		k := e.key
+		for k != nil && !k.Equal(q.ancestor) {
+			k = k.Parent()
+		}
+		if k == nil {
+			return false
+		}
+	}
+	for _, f := range q.filters {
+		if !f.matches(e.props[f.field]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns whether the property, or any of its elements if it's a
+// slice, satisfies the filter.
+func (f filter) matches(prop reflect.Value) bool {
+	if !prop.IsValid() {
+		return false
+	}
+	if prop.Kind() == reflect.Slice && prop.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < prop.Len(); i++ {
+			if f.matches(prop.Index(i)) {
+				return true
+			}
+		}
+		return false
+	}
+	c, ok := compare(prop, reflect.ValueOf(f.value))
+	if !ok {
+		return false
+	}
+	switch f.op {
+	case "=":
+		return c == 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// less returns whether a comes before b in the results of the query.
+func (q *Query) less(a, b *entity) bool {
+	for _, o := range q.orders {
+		field, desc := o, false
+		if strings.HasPrefix(o, "-") {
+			field, desc = o[1:], true
+		}
+		c, _ := compare(a.props[field], b.props[field])
+		if c != 0 {
+			return c < 0 != desc
+		}
+	}
+	return a.key.String() < b.key.String()
+}
+
+// compare compares two property values of the same kind, returning whether
+// they could be compared.
+func compare(a, b reflect.Value) (int, bool) {
+	if !a.IsValid() || !b.IsValid() {
+		return 0, false
+	}
+	switch {
+	case isInt(a) && isInt(b):
+		return sign(a.Int() - b.Int()), true
+	case isFloat(a) && isFloat(b):
+		return signFloat(a.Float() - b.Float()), true
+	case a.Kind() == reflect.String && b.Kind() == reflect.String:
+		return strings.Compare(a.String(), b.String()), true
+	case a.Kind() == reflect.Bool && b.Kind() == reflect.Bool:
+		if a.Bool() == b.Bool() {
+			return 0, true
+		} else if b.Bool() {
+			return -1, true
+		}
+		return 1, true
+	}
+	at, aok := a.Interface().(time.Time)
+	bt, bok := b.Interface().(time.Time)
+	if aok && bok {
+		switch {
+		case at.Before(bt):
+			return -1, true
+		case at.After(bt):
+			return 1, true
+		}
+		return 0, true
+	}
+	ak, aok := a.Interface().(*Key)
+	bk, bok := b.Interface().(*Key)
+	if aok && bok {
+		return strings.Compare(ak.String(), bk.String()), true
+	}
+	return 0, false
+}
+
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isFloat(v reflect.Value) bool {
+	return v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
+
+func sign(n int64) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func signFloat(f float64) int {
+	switch {
+	case f < 0:
+		return -1
+	case f > 0:
+		return 1
+	}
+	return 0
+}
+
+// save returns the stored properties of src, a struct or a pointer to one.
+func save(src interface{}) (map[string]reflect.Value, error) {
+	v := reflect.Indirect(reflect.ValueOf(src))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("platform: entities must be structs, got %T", src)
+	}
+	props := make(map[string]reflect.Value)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := propertyName(t.Field(i))
+		if ok {
+			props[name] = deepCopy(v.Field(i))
+		}
+	}
+	return props, nil
+}
+
+// load sets the fields of dst, a pointer to a struct, from the properties.
+func load(dst interface{}, props map[string]reflect.Value) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("platform: dst must be a pointer to a struct, got %T", dst)
+	}
+	v = v.Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := propertyName(t.Field(i))
+		if !ok {
+			continue
+		}
+		if p, ok := props[name]; ok && p.Type().AssignableTo(t.Field(i).Type) {
+			v.Field(i).Set(deepCopy(p))
+		}
+	}
+	return nil
+}
+
+// propertyName returns the name of the property for a struct field,
+// following its datastore tag, and whether the field is stored.
+func propertyName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	name := strings.Split(f.Tag.Get("datastore"), ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name, true
+}
+
+// deepCopy copies v, so stored entities don't share slices with the ones
+// passed to or returned by the store.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+		return c
+	}
+	return v
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type memEntity struct{ Value string }
+
+func TestMemoryStoreRollback(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	changed, added, outside := NewKey("E", "changed", 0, nil), NewKey("E", "added", 0, nil), NewKey("E", "outside", 0, nil)
+	if _, err := s.Put(ctx, changed, &memEntity{"before"}); err != nil {
+		t.Fatal(err)
+	}
+
+	errFailed := errors.New("failed")
+	err := s.RunInTransaction(ctx, func(tctx context.Context) error {
+		if _, err := s.Put(tctx, changed, &memEntity{"during"}); err != nil {
+			return err
+		}
+		if _, err := s.Put(tctx, added, &memEntity{"during"}); err != nil {
+			return err
+		}
+		// A write outside the transaction, as from a concurrent request.
+		if _, err := s.Put(ctx, outside, &memEntity{"outside"}); err != nil {
+			return err
+		}
+		return errFailed
+	}, false)
+	if err != errFailed {
+		t.Fatalf("got error %v, want %v", err, errFailed)
+	}
+
+	var e memEntity
+	if err := s.Get(ctx, changed, &e); err != nil || e.Value != "before" {
+		t.Errorf("got %v and %q for the changed entity, want it restored", err, e.Value)
+	}
+	if err := s.Get(ctx, added, &e); err != ErrNoSuchEntity {
+		t.Errorf("got %v for the added entity, want it deleted", err)
+	}
+	if err := s.Get(ctx, outside, &e); err != nil || e.Value != "outside" {
+		t.Errorf("got %v and %q for the entity written outside the transaction, want it kept", err, e.Value)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package platform provides the services used by the applications in this
+// workshop, so the same code can run on App Engine or as a plain Go program.
+//
+// The backend is selected at build time: programs built with the appengine
+// build tag, as done by the App Engine SDK, use the App Engine APIs; any other
+// program uses Cloud Datastore, in-process caching and task queues, and logs to
+// standard output.
+package platform
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+)
+
+// Backend contains the implementation of each of the services.
+type Backend struct {
+	// NewContext returns the context for an incoming request.
+	NewContext func(r *http.Request) context.Context
+	// Client returns the HTTP client used for outgoing requests.
+	Client func(ctx context.Context) *http.Client
+	// PublicClient returns the HTTP client used for requests to URLs
+	// given by users, which must not reach private addresses.
+	PublicClient func(ctx context.Context) *http.Client
+	// CurrentUser returns the email of the signed in user, or "" if none.
+	CurrentUser func(ctx context.Context) string
+	// IsAdmin returns whether the request is made by an administrator of
+	// the application.
+	IsAdmin func(ctx context.Context) bool
+
+	Log   Logger
+	Store Store
+	Cache Cache
+	Queue Queue
+}
+
+// Current is the backend used by the functions in this package.
+// It can be replaced, for instance with fakes in tests.
+var Current = defaultBackend()
+
+// A Queue runs tasks in the background, by sending a POST request with the
+// given parameters to a path of the application. Tasks are retried with
+// backoff while the handler fails.
+type Queue interface {
+	Add(ctx context.Context, queue, path string, params url.Values) error
+}
+
+// NewContext returns the context for an incoming request, which carries
+// the request id in its X-Request-ID header, if it's valid.
+func NewContext(r *http.Request) context.Context {
+	ctx := withSpan(Current.NewContext(r), r)
+	if id := r.Header.Get(RequestIDHeader); ValidRequestID(id) {
+		ctx = WithRequestID(ctx, id)
+	}
+	return ctx
+}
+
+// Client returns the HTTP client to use for outgoing requests, which sends
+// the request id in ctx, if any, in their X-Request-ID header and traces
+// them.
+func Client(ctx context.Context) *http.Client {
+	return traced(ctx, Current.Client(ctx))
+}
+
+// PublicClient is like Client, but its requests fail unless they are made
+// to public addresses, as checked by PublicIP, once host names are resolved.
+// It must be used for URLs given by users, so they can't reach the services
+// in the private network of the application.
+func PublicClient(ctx context.Context) *http.Client {
+	return traced(ctx, Current.PublicClient(ctx))
+}
+
+// ErrNotPublic is returned by the requests of PublicClient to addresses that
+// are not public.
+var ErrNotPublic = errors.New("platform: not a public address")
+
+// traced returns a copy of base sending the request id and the trace in ctx.
+func traced(ctx context.Context, base *http.Client) *http.Client {
+	c := *base
+	c.Transport = tracingTransport(ctx, c.Transport)
+	if id := RequestID(ctx); id != "" {
+		c.Transport = requestIDTransport{id, c.Transport}
+	}
+	return &c
+}
+
+// PublicIP returns whether ip is a public unicast address, which excludes
+// the loopback, private, link-local, shared and unspecified ones, and so
+// the metadata servers of cloud providers.
+func PublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// nonPublicNets are the ranges not reachable from the internet that net.IP
+// has no method for.
+var nonPublicNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),      // this network
+	mustParseCIDR("100.64.0.0/10"),  // shared address space, for carrier NAT
+	mustParseCIDR("192.0.0.0/24"),   // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"),  // benchmarking
+	mustParseCIDR("240.0.0.0/4"),    // reserved, and the broadcast address
+	mustParseCIDR("64:ff9b:1::/48"), // local use IPv4/IPv6 translation
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// CurrentUser returns the email of the signed in user, or "" if none.
+func CurrentUser(ctx context.Context) string { return Current.CurrentUser(ctx) }
+
+// IsAdmin returns whether the request is made by an administrator of the
+// application.
+func IsAdmin(ctx context.Context) bool { return Current.IsAdmin(ctx) }
+
+// AddTask adds a task to the given queue.
+func AddTask(ctx context.Context, queue, path string, params url.Values) error {
+	return Current.Queue.Add(ctx, queue, path, params)
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package platformtest provides in-memory fakes for the services in the
+// platform package, so handlers can be tested with net/http/httptest
+// without App Engine or Cloud Datastore:
+//
+//	f, restore := platformtest.Install()
+//	defer restore()
+//	f.Transport.Handler = fakeWeatherAPI
+//
+//	rec := httptest.NewRecorder()
+//	http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/events", nil))
+package platformtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/campoy/go-web-workshop/platform"
+)
+
+// Fakes contains the fake services installed by Install.
+type Fakes struct {
+	Store platform.Store
+	Cache platform.Cache
+	// Transport receives the outgoing HTTP requests.
+	Transport *Transport
+	Queue     *Queue
+	Log       *Logger
+	// User is the email of the signed in user, empty for anonymous requests.
+	User string
+	// Admin is whether requests are made by an administrator.
+	Admin bool
+}
+
+// New returns a new set of fakes, with an empty store and cache.
+func New() *Fakes {
+	return &Fakes{
+		Store:     platform.NewMemoryStore(),
+		Cache:     platform.NewMemoryCache(),
+		Transport: &Transport{},
+		Queue:     &Queue{},
+		Log:       &Logger{},
+	}
+}
+
+// Backend returns a backend using the fakes.
+func (f *Fakes) Backend() *platform.Backend {
+	return &platform.Backend{
+		NewContext: func(r *http.Request) context.Context { return r.Context() },
+		Client:     func(ctx context.Context) *http.Client { return &http.Client{Transport: f.Transport} },
+		// The fake transport doesn't dial, so there are no addresses to check.
+		PublicClient: func(ctx context.Context) *http.Client { return &http.Client{Transport: f.Transport} },
+		CurrentUser:  func(ctx context.Context) string { return f.User },
+		IsAdmin:      func(ctx context.Context) bool { return f.Admin },
+		Log:          f.Log,
+		Store:        f.Store,
+		Cache:        f.Cache,
+		Queue:        f.Queue,
+	}
+}
+
+// Install replaces platform.Current with a backend using new fakes. The
+// returned function restores the previous backend.
+func Install() (*Fakes, func()) {
+	f := New()
+	prev := platform.Current
+	platform.Current = f.Backend()
+	return f, func() { platform.Current = prev }
+}
+
+// Transport is an http.RoundTripper sending requests to Handler, instead of
+// the network. Requests fail if Handler is nil.
+type Transport struct {
+	Handler http.Handler
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.requests = append(t.requests, r)
+	h := t.Handler
+	t.mu.Unlock()
+	if h == nil {
+		return nil, fmt.Errorf("platformtest: no handler for %s %s", r.Method, r.URL)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	res := rec.Result()
+	res.Request = r
+	return res, nil
+}
+
+// Requests returns the requests sent through the transport.
+func (t *Transport) Requests() []*http.Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*http.Request(nil), t.requests...)
+}
+
+// Task is a task added to a Queue.
+type Task struct {
+	Queue  string
+	Path   string
+	Params url.Values
+}
+
+// Request returns the request the task queue would send for the task.
+func (t *Task) Request(retry int) *http.Request {
+	r := httptest.NewRequest("POST", t.Path, strings.NewReader(t.Params.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-AppEngine-QueueName", t.Queue)
+	r.Header.Set("X-AppEngine-TaskRetryCount", strconv.Itoa(retry))
+	return r
+}
+
+// Queue is a platform.Queue keeping the tasks instead of running them.
+type Queue struct {
+	mu    sync.Mutex
+	tasks []*Task
+}
+
+func (q *Queue) Add(ctx context.Context, queue, path string, params url.Values) error {
+	q.mu.Lock()
+	q.tasks = append(q.tasks, &Task{Queue: queue, Path: path, Params: params})
+	q.mu.Unlock()
+	return nil
+}
+
+// Tasks returns the tasks added to the queue and empties it.
+func (q *Queue) Tasks() []*Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	tasks := q.tasks
+	q.tasks = nil
+	return tasks
+}
+
+// Logger is a platform.Logger keeping the entries.
+type Logger struct {
+	mu      sync.Mutex
+	entries []*platform.Entry
+}
+
+func (l *Logger) Log(ctx context.Context, e *platform.Entry) {
+	l.mu.Lock()
+	l.entries = append(l.entries, e)
+	l.mu.Unlock()
+}
+
+// Entries returns the logged entries.
+func (l *Logger) Entries() []*platform.Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*platform.Entry(nil), l.entries...)
+}
+
+// Messages returns the logged messages, prefixed by their severity.
+func (l *Logger) Messages() []string {
+	var msgs []string
+	for _, e := range l.Entries() {
+		msgs = append(msgs, e.Severity+": "+e.Message)
+	}
+	return msgs
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+// +build !appengine
+
+package platform
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// shutdownTimeout is how long ListenAndServe waits for pending requests.
+const shutdownTimeout = 10 * time.Second
+
+// ListenAndServe serves h on the given port.
+// When the process receives SIGINT or SIGTERM it stops accepting connections
+// and waits for the pending requests, and the export of their spans, before
+// returning.
+func ListenAndServe(port int, h http.Handler) error {
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: h}
+
+	done := make(chan error, 1)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		Infof(context.Background(), "received %v, shutting down", <-sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := srv.Shutdown(ctx)
+		if terr := shutdownTracing(ctx); err == nil {
+			err = terr
+		}
+		done <- err
+	}()
+
+	Infof(context.Background(), "listening on port %d", port)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return <-done
+}
@@ -0,0 +1,176 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !appengine
+// +build !appengine
+
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// defaultBackend returns the backend for plain Go programs.
+func defaultBackend() *Backend {
+	return &Backend{
+		NewContext: func(r *http.Request) context.Context { return r.Context() },
+		Client: func(ctx context.Context) *http.Client {
+			return &http.Client{Transport: contextTransport{ctx, http.DefaultTransport}}
+		},
+		PublicClient: func(ctx context.Context) *http.Client {
+			return &http.Client{Transport: contextTransport{ctx, publicTransport}}
+		},
+		CurrentUser: func(ctx context.Context) string { return "" },
+		IsAdmin:     func(ctx context.Context) bool { return ctx.Value(adminKey{}) != nil },
+		Log:         stdLogger{},
+		Store:       &cloudStore{},
+		Cache:       NewMemoryCache(),
+		Queue:       &localQueue{},
+	}
+}
+
+type adminKey struct{}
+
+// WithAdmin returns a copy of ctx for a request made by an administrator,
+// as authenticated by the program since there's no App Engine login.
+func WithAdmin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, adminKey{}, true)
+}
+
+// contextTransport sends requests with the context of the incoming request,
+// so they are canceled with it.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(r.WithContext(t.ctx))
+}
+
+// publicTransport only connects to public addresses. They're checked when
+// dialing, after host names are resolved, so names resolving to private
+// addresses are refused too. It doesn't use proxies, which would be dialed
+// instead.
+var publicTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkPublicAddr,
+	}).DialContext,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+func checkPublicAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+		return fmt.Errorf("dialing %s: %w", address, ErrNotPublic)
+	}
+	return nil
+}
+
+// stdLogger writes log entries to the standard output, one JSON object per
+// line, as expected by Stackdriver Logging.
+type stdLogger struct{}
+
+func (stdLogger) Log(ctx context.Context, e *Entry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"severity":%q,"message":%q}`, Error, "could not encode log entry: "+err.Error()))
+	}
+	os.Stdout.Write(append(b, '\n'))
+}
+
+// Limits to the retries of tasks in localQueue.
+const (
+	maxTaskRetries = 10
+	minTaskBackoff = time.Second
+	maxTaskBackoff = time.Hour
+)
+
+// localQueue runs tasks in the same process, sending their requests to
+// Handler, or http.DefaultServeMux if nil. Pending tasks are lost when
+// the process exits.
+type localQueue struct {
+	Handler http.Handler
+}
+
+func (q *localQueue) Add(ctx context.Context, queue, path string, params url.Values) error {
+	h := q.Handler
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	body := params.Encode()
+
+	go func() {
+		backoff := minTaskBackoff
+		for retry := 0; retry <= maxTaskRetries; retry++ {
+			r, err := http.NewRequest("POST", path, strings.NewReader(body))
+			if err != nil {
+				Errorf(ctx, "creating task for %s: %v", path, err)
+				return
+			}
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("X-AppEngine-QueueName", queue)
+			r.Header.Set("X-AppEngine-TaskRetryCount", strconv.Itoa(retry))
+			if id := RequestID(ctx); id != "" {
+				r.Header.Set(RequestIDHeader, id)
+			}
+			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+			w := &statusRecorder{header: make(http.Header), status: http.StatusOK}
+			h.ServeHTTP(w, r)
+			if w.status < 300 {
+				return
+			}
+			Warningf(ctx, "task for %s in queue %s failed with status %d, retrying in %v", path, queue, w.status, backoff)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxTaskBackoff {
+				backoff = maxTaskBackoff
+			}
+		}
+		Errorf(ctx, "giving up on task for %s in queue %s", path, queue)
+	}()
+	return nil
+}
+
+// statusRecorder is an http.ResponseWriter that discards everything but
+// the status code.
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (w *statusRecorder) Header() http.Header         { return w.header }
+func (w *statusRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (w *statusRecorder) WriteHeader(status int)      { w.status = status }
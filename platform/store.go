@@ -0,0 +1,302 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrNoSuchEntity is returned when no entity was found for a given key.
+	ErrNoSuchEntity = errors.New("platform: no such entity")
+	// Done is returned by Iterator.Next when there are no more results.
+	Done = errors.New("platform: query has no more results")
+)
+
+// A Store stores entities, as the App Engine datastore does. Entities are
+// structs, whose fields are stored following the datastore struct tags.
+type Store interface {
+	Get(ctx context.Context, key *Key, dst interface{}) error
+	Put(ctx context.Context, key *Key, src interface{}) (*Key, error)
+	PutMulti(ctx context.Context, keys []*Key, src interface{}) ([]*Key, error)
+	Delete(ctx context.Context, key *Key) error
+	DeleteMulti(ctx context.Context, keys []*Key) error
+	GetAll(ctx context.Context, q *Query, dst interface{}) ([]*Key, error)
+	Run(ctx context.Context, q *Query) Iterator
+	// RunInTransaction runs f in a transaction. Operations in f must use
+	// the context it receives. Transactions on more than one entity group
+	// need crossGroup to be true.
+	RunInTransaction(ctx context.Context, f func(ctx context.Context) error, crossGroup bool) error
+}
+
+// An Iterator is the result of running a query.
+type Iterator interface {
+	// Next loads the next entity into dst and returns its key.
+	// It returns Done when there are no more results.
+	Next(dst interface{}) (*Key, error)
+	// Cursor returns a cursor for the position after the last result.
+	Cursor() (string, error)
+}
+
+// errIterator is an Iterator returning an error, for queries that can't run.
+type errIterator struct{ err error }
+
+func (it errIterator) Next(dst interface{}) (*Key, error) { return nil, it.err }
+func (it errIterator) Cursor() (string, error)            { return "", it.err }
+
+// Key identifies an entity. Keys are complete when they have a name or
+// an id, and incomplete keys get an id assigned when they are put.
+type Key struct {
+	kind   string
+	name   string
+	id     int64
+	parent *Key
+}
+
+// NewKey returns a key with the given kind and either a name or an id.
+func NewKey(kind, name string, id int64, parent *Key) *Key {
+	return &Key{kind: kind, name: name, id: id, parent: parent}
+}
+
+// NewIncompleteKey returns a key with the given kind and no name or id.
+func NewIncompleteKey(kind string, parent *Key) *Key {
+	return &Key{kind: kind, parent: parent}
+}
+
+// Kind returns the kind of the entity identified by the key.
+func (k *Key) Kind() string { return k.kind }
+
+// StringID returns the name of the key, or "" if it has none.
+func (k *Key) StringID() string { return k.name }
+
+// IntID returns the id of the key, or 0 if it has none.
+func (k *Key) IntID() int64 { return k.id }
+
+// Parent returns the parent key, or nil if there's none.
+func (k *Key) Parent() *Key { return k.parent }
+
+// Incomplete returns whether the key has neither a name nor an id.
+func (k *Key) Incomplete() bool { return k.name == "" && k.id == 0 }
+
+// Equal returns whether both keys identify the same entity.
+func (k *Key) Equal(o *Key) bool {
+	for k != nil && o != nil {
+		if k.kind != o.kind || k.name != o.name || k.id != o.id {
+			return false
+		}
+		k, o = k.parent, o.parent
+	}
+	return k == o
+}
+
+func (k *Key) String() string {
+	if k == nil {
+		return ""
+	}
+	id := k.name
+	if id == "" {
+		id = fmt.Sprint(k.id)
+	}
+	if k.parent == nil {
+		return "/" + k.kind + "," + id
+	}
+	return k.parent.String() + "/" + k.kind + "," + id
+}
+
+// Query is a query on the entities of a kind. Its methods return a modified
+// copy of the query, as the ones in the App Engine datastore package.
+type Query struct {
+	kind     string
+	ancestor *Key
+	filters  []filter
+	orders   []string
+	limit    int
+	keysOnly bool
+	start    string
+}
+
+type filter struct {
+	field string
+	op    string
+	value interface{}
+}
+
+// NewQuery returns a query for entities of the given kind.
+func NewQuery(kind string) *Query { return &Query{kind: kind, limit: -1} }
+
+func (q *Query) clone() *Query {
+	c := *q
+	c.filters = append([]filter(nil), q.filters...)
+	c.orders = append([]string(nil), q.orders...)
+	return &c
+}
+
+// Filter returns a query with a field-based filter, such as "Date >".
+// The supported operators are =, <, <=, > and >=.
+func (q *Query) Filter(filterStr string, value interface{}) *Query {
+	q = q.clone()
+	f := strings.Fields(filterStr)
+	if len(f) == 1 {
+		f = append(f, "=")
+	}
+	q.filters = append(q.filters, filter{field: f[0], op: f[1], value: value})
+	return q
+}
+
+// Order returns a query ordered by the given field, prefixed with a minus
+// sign for descending order.
+func (q *Query) Order(field string) *Query {
+	q = q.clone()
+	q.orders = append(q.orders, field)
+	return q
+}
+
+// Limit returns a query returning at most limit results.
+func (q *Query) Limit(limit int) *Query {
+	q = q.clone()
+	q.limit = limit
+	return q
+}
+
+// Ancestor returns a query for the descendants of the given key.
+func (q *Query) Ancestor(ancestor *Key) *Query {
+	q = q.clone()
+	q.ancestor = ancestor
+	return q
+}
+
+// KeysOnly returns a query returning only keys.
+func (q *Query) KeysOnly() *Query {
+	q = q.clone()
+	q.keysOnly = true
+	return q
+}
+
+// Start returns a query starting at the given cursor.
+func (q *Query) Start(cursor string) *Query {
+	q = q.clone()
+	q.start = cursor
+	return q
+}
+
+// GetAll runs the query and loads all the results into dst, which must be a
+// pointer to a slice of structs, or nil for keys only queries.
+func (q *Query) GetAll(ctx context.Context, dst interface{}) ([]*Key, error) {
+	ctx, span := StartSpan(ctx, "datastore.GetAll "+q.kind)
+	defer span.End()
+	keys, err := Current.Store.GetAll(ctx, q, dst)
+	setSpanError(span, err)
+	span.SetAttributes(attribute.Int("datastore.results", len(keys)))
+	return keys, err
+}
+
+// Run runs the query and returns an iterator over its results. Its span
+// ends once the first result is loaded, since the iterator may not be read
+// until the end.
+func (q *Query) Run(ctx context.Context) Iterator {
+	ctx, span := StartSpan(ctx, "datastore.Run "+q.kind)
+	return &tracedIterator{Current.Store.Run(ctx, q), span}
+}
+
+// tracedIterator ends the span of the query when the first result is loaded.
+type tracedIterator struct {
+	Iterator
+	span trace.Span
+}
+
+func (it *tracedIterator) Next(dst interface{}) (*Key, error) {
+	key, err := it.Iterator.Next(dst)
+	if it.span != nil {
+		if err != Done {
+			setSpanError(it.span, err)
+		}
+		it.span.End()
+		it.span = nil
+	}
+	return key, err
+}
+
+// Get loads the entity with the given key into dst.
+func Get(ctx context.Context, key *Key, dst interface{}) error {
+	ctx, span := StartSpan(ctx, "datastore.Get "+key.Kind())
+	defer span.End()
+	err := Current.Store.Get(ctx, key, dst)
+	if err != ErrNoSuchEntity {
+		setSpanError(span, err)
+	}
+	return err
+}
+
+// Put saves src with the given key, and returns the complete key.
+func Put(ctx context.Context, key *Key, src interface{}) (*Key, error) {
+	ctx, span := StartSpan(ctx, "datastore.Put "+key.Kind())
+	defer span.End()
+	key, err := Current.Store.Put(ctx, key, src)
+	setSpanError(span, err)
+	return key, err
+}
+
+// PutMulti is a batch version of Put, src must be a slice.
+func PutMulti(ctx context.Context, keys []*Key, src interface{}) ([]*Key, error) {
+	ctx, span := StartSpan(ctx, "datastore.PutMulti")
+	defer span.End()
+	span.SetAttributes(attribute.Int("datastore.entities", len(keys)))
+	keys, err := Current.Store.PutMulti(ctx, keys, src)
+	setSpanError(span, err)
+	return keys, err
+}
+
+// Delete deletes the entity with the given key.
+func Delete(ctx context.Context, key *Key) error {
+	ctx, span := StartSpan(ctx, "datastore.Delete "+key.Kind())
+	defer span.End()
+	err := Current.Store.Delete(ctx, key)
+	setSpanError(span, err)
+	return err
+}
+
+// DeleteMulti is a batch version of Delete.
+func DeleteMulti(ctx context.Context, keys []*Key) error {
+	ctx, span := StartSpan(ctx, "datastore.DeleteMulti")
+	defer span.End()
+	span.SetAttributes(attribute.Int("datastore.entities", len(keys)))
+	err := Current.Store.DeleteMulti(ctx, keys)
+	setSpanError(span, err)
+	return err
+}
+
+// RunInTransaction runs f in a transaction, see Store. The operations in f
+// are traced as children of the span of the transaction.
+func RunInTransaction(ctx context.Context, f func(ctx context.Context) error, crossGroup bool) error {
+	ctx, span := StartSpan(ctx, "datastore.RunInTransaction")
+	defer span.End()
+	err := Current.Store.RunInTransaction(ctx, f, crossGroup)
+	setSpanError(span, err)
+	return err
+}
+
+// setSpanError marks the span as failed if err is not nil.
+func setSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStoreSpans(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	prevProvider, prevBackend := otel.GetTracerProvider(), Current
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	Current = &Backend{Store: NewMemoryStore()}
+	defer func() {
+		otel.SetTracerProvider(prevProvider)
+		Current = prevBackend
+	}()
+
+	ctx := context.Background()
+	err := RunInTransaction(ctx, func(ctx context.Context) error {
+		_, err := Put(ctx, NewKey("E", "a", 0, nil), &memEntity{"a"})
+		return err
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e memEntity
+	if err := Get(ctx, NewKey("E", "a", 0, nil), &e); err != nil {
+		t.Fatal(err)
+	}
+	it := NewQuery("E").Run(ctx)
+	for {
+		if _, err := it.Next(&e); err == Done {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Delete(ctx, NewKey("E", "a", 0, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, s := range spans.Ended() {
+		names = append(names, s.Name())
+	}
+	got := strings.Join(names, ", ")
+	want := "datastore.Put E, datastore.RunInTransaction, datastore.Get E, datastore.Run E, datastore.Delete E"
+	if got != want {
+		t.Errorf("got spans %s, want %s", got, want)
+	}
+}
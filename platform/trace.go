@@ -0,0 +1,146 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by this package.
+const tracerName = "github.com/campoy/go-web-workshop/platform"
+
+var (
+	tracingMu sync.Mutex
+	// tracerProvider is the provider set up by SetupTracing, if any.
+	tracerProvider *sdktrace.TracerProvider
+)
+
+// CheckTracesExporter returns an error unless name is an exporter known to
+// SetupTracing.
+func CheckTracesExporter(name string) error {
+	switch name {
+	case "", "none", "console", "otlp":
+		return nil
+	}
+	return fmt.Errorf("unknown traces exporter %q, use none, console or otlp", name)
+}
+
+// SetupTracing sets up OpenTelemetry tracing for the given service, with the
+// given exporter:
+//
+//   - "console" writes the spans to the standard output.
+//   - "otlp" sends them to the OpenTelemetry collector at the endpoint URL, or
+//     on localhost:4318 if it's empty.
+//   - "none", or an empty value, doesn't export spans.
+//
+// W3C trace context headers are read from incoming requests and sent with
+// the requests made with Client and the tasks added to queues.
+func SetupTracing(service, exporterName, endpoint string) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if err := CheckTracesExporter(exporterName); err != nil {
+		return err
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case "", "none":
+		return nil
+	case "console":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	}
+	if err != nil {
+		return fmt.Errorf("could not create %s exporter: %v", exporterName, err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+	)
+	otel.SetTracerProvider(tp)
+
+	tracingMu.Lock()
+	tracerProvider = tp
+	tracingMu.Unlock()
+	return nil
+}
+
+// shutdownTracing exports the pending spans, if tracing was set up.
+func shutdownTracing(ctx context.Context) error {
+	tracingMu.Lock()
+	tp := tracerProvider
+	tracingMu.Unlock()
+	if tp == nil {
+		return nil
+	}
+	return tp.Shutdown(ctx)
+}
+
+// StartSpan starts a span as a child of the one in ctx, if any. The span
+// must be ended by calling its End method.
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name)
+}
+
+// withSpan returns ctx with the span in the context of the request, since
+// the context returned by the backend doesn't always derive from it.
+func withSpan(ctx context.Context, r *http.Request) context.Context {
+	if span := trace.SpanFromContext(r.Context()); span.SpanContext().IsValid() {
+		return trace.ContextWithSpan(ctx, span)
+	}
+	return ctx
+}
+
+// tracingTransport creates a span for each outgoing request, as a child of
+// the span in ctx, and sends its context in the traceparent header.
+func tracingTransport(ctx context.Context, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return spanTransport{trace.SpanFromContext(ctx), otelhttp.NewTransport(base)}
+}
+
+// spanTransport sends requests with the given span as their parent, unless
+// their context already has one.
+type spanTransport struct {
+	span trace.Span
+	base http.RoundTripper
+}
+
+func (t spanTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !trace.SpanContextFromContext(r.Context()).IsValid() {
+		r = r.WithContext(trace.ContextWithSpan(r.Context(), t.span))
+	}
+	return t.base.RoundTrip(r)
+}
@@ -18,10 +18,7 @@ import (
 	"io/ioutil"
 	"net/http"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
-
+	"github.com/campoy/go-web-workshop/platform"
 	"github.com/gorilla/mux"
 )
 
@@ -43,10 +40,10 @@ func init() {
 }
 
 func getNamespaces(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
-	log.Infof(ctx, "getNamespaces")
+	ctx := platform.NewContext(r)
+	platform.Infof(ctx, "getNamespaces")
 
-	keys, err := datastore.NewQuery(namespaceKind).KeysOnly().GetAll(ctx, nil)
+	keys, err := platform.NewQuery(namespaceKind).KeysOnly().GetAll(ctx, nil)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -66,23 +63,23 @@ func (h csrfHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 type withNamespace struct {
-	h func(w http.ResponseWriter, r *http.Request, namespace *datastore.Key)
+	h func(w http.ResponseWriter, r *http.Request, namespace *platform.Key)
 
 	createIfMissing bool
 }
 
 func (h withNamespace) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+	ctx := platform.NewContext(r)
 
 	namespace := mux.Vars(r)["namespace"]
-	key := datastore.NewKey(ctx, namespaceKind, namespace, 0, nil)
-	if err := datastore.Get(ctx, key, new(struct{})); err == datastore.ErrNoSuchEntity {
+	key := platform.NewKey(namespaceKind, namespace, 0, nil)
+	if err := platform.Get(ctx, key, new(struct{})); err == platform.ErrNoSuchEntity {
 		if !h.createIfMissing {
 			http.Error(w, fmt.Sprintf("namespace %s not found", namespace), http.StatusNotFound)
 			return
 		}
 
-		_, err := datastore.Put(ctx, key, new(struct{}))
+		_, err := platform.Put(ctx, key, new(struct{}))
 		if err != nil {
 			http.Error(w, fmt.Sprintf("could not create namespace %s: %v", namespace, err), http.StatusInternalServerError)
 			return
@@ -94,12 +91,12 @@ func (h withNamespace) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.h(w, r, key)
 }
 
-func getAll(w http.ResponseWriter, r *http.Request, namespace *datastore.Key) {
-	ctx := appengine.NewContext(r)
-	log.Infof(ctx, "getAll")
+func getAll(w http.ResponseWriter, r *http.Request, namespace *platform.Key) {
+	ctx := platform.NewContext(r)
+	platform.Infof(ctx, "getAll")
 
 	values := []value{}
-	keys, err := datastore.NewQuery(valueKind).Ancestor(namespace).GetAll(ctx, &values)
+	keys, err := platform.NewQuery(valueKind).Ancestor(namespace).GetAll(ctx, &values)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -118,16 +115,16 @@ func getAll(w http.ResponseWriter, r *http.Request, namespace *datastore.Key) {
 	}
 }
 
-func getOne(w http.ResponseWriter, r *http.Request, namespace *datastore.Key) {
-	ctx := appengine.NewContext(r)
-	log.Infof(ctx, "getOne")
+func getOne(w http.ResponseWriter, r *http.Request, namespace *platform.Key) {
+	ctx := platform.NewContext(r)
+	platform.Infof(ctx, "getOne")
 
 	vars := mux.Vars(r)
 	keyName := vars["key"]
-	key := datastore.NewKey(ctx, valueKind, keyName, 0, namespace)
+	key := platform.NewKey(valueKind, keyName, 0, namespace)
 
 	var v value
-	if err := datastore.Get(ctx, key, &v); err == datastore.ErrNoSuchEntity {
+	if err := platform.Get(ctx, key, &v); err == platform.ErrNoSuchEntity {
 		http.Error(w, fmt.Sprintf("key %s not found in namespace %v", keyName, namespace.StringID()), http.StatusNotFound)
 		return
 	} else if err != nil {
@@ -139,13 +136,13 @@ func getOne(w http.ResponseWriter, r *http.Request, namespace *datastore.Key) {
 	fmt.Fprintln(w, v.Value)
 }
 
-func put(w http.ResponseWriter, r *http.Request, namespace *datastore.Key) {
-	ctx := appengine.NewContext(r)
-	log.Infof(ctx, "put")
+func put(w http.ResponseWriter, r *http.Request, namespace *platform.Key) {
+	ctx := platform.NewContext(r)
+	platform.Infof(ctx, "put")
 
 	vars := mux.Vars(r)
 	keyName := vars["key"]
-	key := datastore.NewKey(ctx, valueKind, keyName, 0, namespace)
+	key := platform.NewKey(valueKind, keyName, 0, namespace)
 
 	b, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -154,20 +151,20 @@ func put(w http.ResponseWriter, r *http.Request, namespace *datastore.Key) {
 	}
 	v := value{string(b)}
 
-	if _, err := datastore.Put(ctx, key, &v); err != nil {
+	if _, err := platform.Put(ctx, key, &v); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func delete(w http.ResponseWriter, r *http.Request, namespace *datastore.Key) {
-	ctx := appengine.NewContext(r)
-	log.Infof(ctx, "delete")
+func delete(w http.ResponseWriter, r *http.Request, namespace *platform.Key) {
+	ctx := platform.NewContext(r)
+	platform.Infof(ctx, "delete")
 
 	vars := mux.Vars(r)
 	keyName := vars["key"]
 
-	key := datastore.NewKey(ctx, valueKind, keyName, 0, namespace)
-	if err := datastore.Delete(ctx, key); err != nil {
+	key := platform.NewKey(valueKind, keyName, 0, namespace)
+	if err := platform.Delete(ctx, key); err != nil {
 		http.Error(w, fmt.Sprintf("fetching value %s in %s: %v", keyName, namespace, err), http.StatusInternalServerError)
 	}
 }